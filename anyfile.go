@@ -0,0 +1,187 @@
+package acode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// TargetFile is a file that should be generated by OpGenAnyFile
+type TargetFile struct {
+	Path        string // relative to Config.Directory
+	Description string // what the file should contain
+}
+
+// GeneratedFile is a file that was returned by the AI
+type GeneratedFile struct {
+	Path     string `json:"path"`
+	Contents string `json:"contents"`
+}
+
+type fileEnvelope struct {
+	Files []GeneratedFile `json:"files"`
+}
+
+var (
+	fileStartRegexp = regexp.MustCompile(`^=== FILE: (.+?) ===\s*$`)
+	fileEndMarker   = "=== END FILE ==="
+)
+
+// targetFilesList returns the target files as a list that can be used in a prompt
+func (cfg *Config) targetFilesList() string {
+	if len(cfg.TargetFiles) == 0 {
+		return "\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\n")
+	for _, tf := range cfg.TargetFiles {
+		fmt.Fprintf(&sb, "- %s: %s\n", filepath.ToSlash(tf.Path), tf.Description)
+	}
+	return sb.String()
+}
+
+// ParseFileEnvelope extracts generated files from an AI response. The response may contain one or more JSON envelopes
+// like {"files": [{"path": "...", "contents": "..."}]}, or files delimited by "=== FILE: path ===" and "=== END FILE ===".
+// If the same path is given more than once, the last one is used.
+func ParseFileEnvelope(response string) ([]GeneratedFile, error) {
+	var generated []GeneratedFile
+	if trimmed := strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response))); strings.HasPrefix(trimmed, "{") {
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		for {
+			var envelope fileEnvelope
+			if err := dec.Decode(&envelope); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("could not parse the JSON file envelope: %v", err)
+			}
+			generated = append(generated, envelope.Files...)
+		}
+	} else {
+		var (
+			current *GeneratedFile
+			lines   []string
+		)
+		for _, line := range strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n") {
+			if m := fileStartRegexp.FindStringSubmatch(line); m != nil && current == nil {
+				current = &GeneratedFile{Path: strings.TrimSpace(m[1])}
+				lines = nil
+				continue
+			}
+			if current != nil && strings.TrimSpace(line) == fileEndMarker {
+				current.Contents = strings.Join(lines, "\n") + "\n"
+				generated = append(generated, *current)
+				current = nil
+				continue
+			}
+			if current != nil {
+				lines = append(lines, line)
+			}
+		}
+		if current != nil {
+			return nil, fmt.Errorf("missing %q for %s", fileEndMarker, current.Path)
+		}
+	}
+	if len(generated) == 0 {
+		return nil, fmt.Errorf("no files found in the response")
+	}
+	// Keep the last version of each file, but in the order they were first given
+	var (
+		result []GeneratedFile
+		index  = make(map[string]int)
+	)
+	for _, gf := range generated {
+		key := filepath.Clean(filepath.FromSlash(gf.Path))
+		if i, ok := index[key]; ok {
+			result[i] = gf
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, gf)
+	}
+	return result, nil
+}
+
+// SafePath returns the full path for a path that is relative to dir, or an error if the path points outside of dir,
+// either directly or by following symbolic links
+func SafePath(dir, rel string) (string, error) {
+	rel = filepath.FromSlash(rel)
+	if rel == "" || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || !insideDirectory(rel) {
+		return "", fmt.Errorf("refusing to write %s, since it is outside of %s", rel, dir)
+	}
+	full := filepath.Join(dir, rel)
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	// Check the closest existing parent directory, in case it is a symlink that points elsewhere
+	parent := filepath.Dir(full)
+	for !isExistingDir(parent) && parent != dir && parent != "." {
+		parent = filepath.Dir(parent)
+	}
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	if r, err := filepath.Rel(realDir, realParent); err != nil || !insideDirectory(r) {
+		return "", fmt.Errorf("refusing to write %s, since it is outside of %s", rel, dir)
+	}
+	if fi, err := os.Lstat(full); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("refusing to write %s, since it is a symbolic link", rel)
+	}
+	return full, nil
+}
+
+// isExistingDir checks if the given path is an existing directory
+func isExistingDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// WriteGeneratedFiles writes the generated files to cfg.Directory. Files that are not among cfg.TargetFiles (if given),
+// or that would end up outside of cfg.Directory, are refused. Existing files are only overwritten if confirmed.
+// Returns the paths of the written files and an error for each file that was not written.
+func (cfg *Config) WriteGeneratedFiles(generated []GeneratedFile) ([]string, []error) {
+	var (
+		written []string
+		errs    []error
+		targets = make(map[string]bool)
+	)
+	for _, tf := range cfg.TargetFiles {
+		targets[filepath.Clean(filepath.FromSlash(tf.Path))] = true
+	}
+	for _, gf := range generated {
+		rel := filepath.Clean(filepath.FromSlash(gf.Path))
+		if len(targets) > 0 && !targets[rel] {
+			errs = append(errs, fmt.Errorf("refusing to write %s, since it is not one of the target files", gf.Path))
+			continue
+		}
+		full, err := SafePath(cfg.Directory, rel)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if isExistingDir(full) {
+			errs = append(errs, fmt.Errorf("refusing to write %s, since it is a directory", gf.Path))
+			continue
+		}
+		if _, err := os.Stat(full); err == nil && !cfg.confirm(full+" already exists. Overwrite it?", "") {
+			errs = append(errs, fmt.Errorf("did not overwrite %s: %w", full, ErrNotConfirmed))
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			errs = append(errs, fmt.Errorf("could not create the directory for %s: %v", full, err))
+			continue
+		}
+		if err := cfg.writeOutputFile(full, []byte(gf.Contents)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write %s: %v", full, err))
+			continue
+		}
+		written = append(written, full)
+	}
+	return written, errs
+}
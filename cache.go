@@ -0,0 +1,221 @@
+package acode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is how long a cached response is used, if Config.CacheTTL is not set
+	DefaultCacheTTL = 7 * 24 * time.Hour
+
+	// DefaultCacheMaxSize is the maximum total size of the cached responses in bytes, if Config.CacheMaxSize is not set
+	DefaultCacheMaxSize = 100 * 1024 * 1024
+
+	cacheVersion = 1
+)
+
+// CacheEntry is a cached response to a prompt
+type CacheEntry struct {
+	Version  int       `json:"version"`
+	Model    string    `json:"model"` // the model that gave the response, which may be the fallback model
+	Created  time.Time `json:"created"`
+	Response string    `json:"response"`
+}
+
+// pendingResponse is a response that is cached once the generated file it is part of has been validated
+type pendingResponse struct {
+	prompt, modelName, response string
+}
+
+// cacheDirectory returns the directory where the cached responses are stored
+func (cfg *Config) cacheDirectory() string {
+	if cfg.CacheDir != "" {
+		return cfg.CacheDir
+	}
+	return filepath.Join(cfg.Directory, ".acode", "cache")
+}
+
+// cacheKey returns the content address of a prompt. The prompt already contains the template and the source code chunk,
+// so the model, the server and the operation are all that needs to be added.
+func (cfg *Config) cacheKey(prompt string) string {
+	h := sha256.New()
+	for _, s := range []string{cfg.Model.Name, cfg.Model.PostURL, fmt.Sprintf("%d", cfg.OpType), prompt} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheFilename returns the filename of the cache entry with the given key
+func (cfg *Config) cacheFilename(key string) string {
+	return filepath.Join(cfg.cacheDirectory(), key[:2], key+".json")
+}
+
+// cacheTTL returns how long cached responses are used
+func (cfg *Config) cacheTTL() time.Duration {
+	if cfg.CacheTTL > 0 {
+		return cfg.CacheTTL
+	}
+	return DefaultCacheTTL
+}
+
+// cacheMaxSize returns the maximum total size of the cache, in bytes
+func (cfg *Config) cacheMaxSize() int64 {
+	if cfg.CacheMaxSize > 0 {
+		return cfg.CacheMaxSize
+	}
+	return DefaultCacheMaxSize
+}
+
+// cachedResponse returns the cached response to the given prompt, if there is one that has not expired.
+// Expired entries are removed. Returns false if the cache is disabled, or if it should be refreshed.
+func (cfg *Config) cachedResponse(prompt string) (CacheEntry, bool) {
+	if cfg.NoCache || cfg.RefreshCache {
+		return CacheEntry{}, false
+	}
+	filename := cfg.cacheFilename(cfg.cacheKey(prompt))
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Version != cacheVersion {
+		os.Remove(filename)
+		return CacheEntry{}, false
+	}
+	if time.Since(entry.Created) > cfg.cacheTTL() {
+		os.Remove(filename)
+		return CacheEntry{}, false
+	}
+	// Mark the entry as recently used, so that it is pruned last
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	return entry, true
+}
+
+// storeResponse stores the response to the given prompt in the cache.
+// For operations with a validator, the response is kept aside until storePendingResponses is called, so that responses
+// that make up a file that does not validate are never cached. Errors are only logged, since the response is still valid.
+func (cfg *Config) storeResponse(prompt, modelName, response string) {
+	if cfg.NoCache || strings.TrimSpace(response) == "" {
+		return
+	}
+	if _, ok := validators[cfg.OpType]; ok {
+		cfg.pendingResponses = append(cfg.pendingResponses, pendingResponse{prompt, modelName, response})
+		return
+	}
+	cfg.writeCacheEntry(prompt, modelName, response)
+}
+
+// storePendingResponses caches the responses that were kept aside by storeResponse, once the generated file is valid
+func (cfg *Config) storePendingResponses() {
+	for _, p := range cfg.pendingResponses {
+		cfg.writeCacheEntry(p.prompt, p.modelName, p.response)
+	}
+	cfg.pendingResponses = nil
+}
+
+// writeCacheEntry writes the response to the given prompt to the cache. The cache is pruned later, by pruneCacheIfWritten.
+func (cfg *Config) writeCacheEntry(prompt, modelName, response string) {
+	filename := cfg.cacheFilename(cfg.cacheKey(prompt))
+	data, err := json.Marshal(CacheEntry{
+		Version:  cacheVersion,
+		Model:    modelName,
+		Created:  time.Now(),
+		Response: response,
+	})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+	}
+	if err == nil {
+		err = writeFileAtomic(filename, data, 0644)
+	}
+	if err == nil {
+		cfg.cacheWritten = true
+	} else if !cfg.Silent {
+		log.Printf("warning: could not cache the response: %v\n", err)
+	}
+}
+
+// pruneCacheIfWritten prunes the cache if any responses have been written to it since the last time, so that the
+// cache directory is walked once per Process call or chat question, instead of once per response
+func (cfg *Config) pruneCacheIfWritten() {
+	if !cfg.cacheWritten {
+		return
+	}
+	cfg.cacheWritten = false
+	if err := cfg.PruneCache(); err != nil && !cfg.Silent {
+		log.Printf("warning: could not prune the cache: %v\n", err)
+	}
+}
+
+// PruneCache removes the expired cache entries, and then the least recently used entries
+// until the total size of the cache is within the size limit
+func (cfg *Config) PruneCache() error {
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		entries   []cacheFile
+		totalSize int64
+		ttl       = cfg.cacheTTL()
+	)
+	err := filepath.Walk(cfg.cacheDirectory(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		// The modification time is updated when an entry is used, so an entry that has not been
+		// modified for longer than the TTL has certainly expired
+		if time.Since(info.ModTime()) > ttl {
+			return os.Remove(path)
+		}
+		entries = append(entries, cacheFile{path, info.Size(), info.ModTime()})
+		totalSize += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not prune the response cache: %v", err)
+	}
+	maxSize := cfg.cacheMaxSize()
+	if totalSize <= maxSize {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, entry := range entries {
+		if totalSize <= maxSize {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil {
+			return fmt.Errorf("could not prune the response cache: %v", err)
+		}
+		totalSize -= entry.size
+	}
+	return nil
+}
+
+// ClearCache removes all cached responses
+func (cfg *Config) ClearCache() error {
+	if err := os.RemoveAll(cfg.cacheDirectory()); err != nil {
+		return fmt.Errorf("could not clear the response cache: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package acode
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	entityNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-_.a-zA-Z0-9]{0,61}[a-zA-Z0-9])?$`)
+	entityTagRegexp  = regexp.MustCompile(`^[a-z0-9:+#]+(-[a-z0-9:+#]+)*$`)
+)
+
+// catalogSpecFields lists the required and the optional spec fields for the Backstage entity kinds that are checked in detail
+var catalogSpecFields = map[string]struct {
+	required []string
+	optional []string
+}{
+	"Component": {
+		required: []string{"type", "lifecycle", "owner"},
+		optional: []string{"system", "subcomponentOf", "providesApis", "consumesApis", "dependsOn", "dependencyOf"},
+	},
+	"API": {
+		required: []string{"type", "lifecycle", "owner", "definition"},
+		optional: []string{"system"},
+	},
+	"System": {
+		required: []string{"owner"},
+		optional: []string{"domain", "type"},
+	},
+	"Resource": {
+		required: []string{"type", "owner"},
+		optional: []string{"system", "dependsOn", "dependencyOf"},
+	},
+}
+
+// otherCatalogKinds are Backstage entity kinds where only apiVersion, kind and metadata are checked
+var otherCatalogKinds = []string{"Domain", "Group", "User", "Location", "Template"}
+
+// ValidateCatalog parses the given Backstage catalog YAML and checks the apiVersion, kind, metadata and spec of each entity.
+// Returns a list of validation errors, which is empty if the catalog is valid.
+func ValidateCatalog(text string) []error {
+	docs, err := ParseYAML(text)
+	if err != nil {
+		return []error{fmt.Errorf("invalid YAML: %v", err)}
+	}
+	if len(docs) == 0 {
+		return []error{fmt.Errorf("the catalog file contains no entities")}
+	}
+	var errs []error
+	for i, doc := range docs {
+		prefix := ""
+		if len(docs) > 1 {
+			prefix = fmt.Sprintf("document %d: ", i+1)
+		}
+		for _, err := range validateCatalogEntity(doc) {
+			errs = append(errs, fmt.Errorf("%s%v", prefix, err))
+		}
+	}
+	return errs
+}
+
+// validateCatalogEntity checks a single parsed Backstage entity
+func validateCatalogEntity(doc interface{}) []error {
+	entity, ok := doc.(map[string]interface{})
+	if !ok {
+		return []error{fmt.Errorf("the entity must be a YAML mapping")}
+	}
+	var errs []error
+
+	apiVersion, _ := entity["apiVersion"].(string)
+	if apiVersion == "" {
+		errs = append(errs, fmt.Errorf("apiVersion is missing"))
+	} else if !strings.HasPrefix(apiVersion, "backstage.io/") {
+		errs = append(errs, fmt.Errorf("apiVersion must be backstage.io/v1alpha1 or backstage.io/v1beta1, got %q", apiVersion))
+	}
+
+	kind, _ := entity["kind"].(string)
+	fields, known := catalogSpecFields[kind]
+	switch {
+	case kind == "":
+		errs = append(errs, fmt.Errorf("kind is missing"))
+	case !known && !hasString(otherCatalogKinds, kind):
+		errs = append(errs, fmt.Errorf("unknown kind %q, expected Component, API, System or Resource", kind))
+	}
+
+	errs = append(errs, validateCatalogMetadata(entity["metadata"])...)
+
+	if !known {
+		return errs
+	}
+	spec, ok := entity["spec"].(map[string]interface{})
+	if !ok {
+		return append(errs, fmt.Errorf("spec is missing or is not a mapping"))
+	}
+	for _, field := range fields.required {
+		if field == "definition" && isSubstitution(spec[field]) {
+			continue
+		}
+		if s, ok := spec[field].(string); !ok || strings.TrimSpace(s) == "" {
+			errs = append(errs, fmt.Errorf("spec.%s is required for kind %s", field, kind))
+		}
+	}
+	for _, field := range sortedKeys(spec) {
+		value := spec[field]
+		switch {
+		case hasString(fields.required, field):
+		case !hasString(fields.optional, field):
+			errs = append(errs, fmt.Errorf("spec.%s is not a known field for kind %s", field, kind))
+		case strings.HasSuffix(field, "Apis") || strings.HasPrefix(field, "depend"):
+			if !isStringList(value) {
+				errs = append(errs, fmt.Errorf("spec.%s must be a list of entity references", field))
+			}
+		default:
+			if _, ok := value.(string); !ok {
+				errs = append(errs, fmt.Errorf("spec.%s must be a string", field))
+			}
+		}
+	}
+	return errs
+}
+
+// isSubstitution checks if the value is a Backstage placeholder, like {$text: ./openapi.yaml}, which is a mapping
+// with a single $text, $json or $yaml key and a string value
+func isSubstitution(value interface{}) bool {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false
+	}
+	for _, key := range []string{"$text", "$json", "$yaml"} {
+		if s, ok := m[key].(string); ok && strings.TrimSpace(s) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCatalogMetadata checks the metadata section of a Backstage entity
+func validateCatalogMetadata(value interface{}) []error {
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return []error{fmt.Errorf("metadata is missing or is not a mapping")}
+	}
+	var errs []error
+	name, _ := metadata["name"].(string)
+	if name == "" {
+		errs = append(errs, fmt.Errorf("metadata.name is missing"))
+	} else if !entityNameRegexp.MatchString(name) {
+		errs = append(errs, fmt.Errorf("metadata.name %q must be 1-63 characters of [a-zA-Z0-9-_.], starting and ending with a letter or digit", name))
+	}
+	for _, field := range []string{"namespace", "title", "description"} {
+		if v, exists := metadata[field]; exists {
+			if _, ok := v.(string); !ok {
+				errs = append(errs, fmt.Errorf("metadata.%s must be a string", field))
+			}
+		}
+	}
+	for _, field := range []string{"labels", "annotations"} {
+		v, exists := metadata[field]
+		if !exists {
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("metadata.%s must be a mapping", field))
+			continue
+		}
+		for _, key := range sortedKeys(m) {
+			if _, ok := m[key].(string); !ok {
+				errs = append(errs, fmt.Errorf("metadata.%s.%s must be a string", field, key))
+			}
+		}
+	}
+	if v, exists := metadata["tags"]; exists {
+		if !isStringList(v) {
+			errs = append(errs, fmt.Errorf("metadata.tags must be a list of strings"))
+		} else {
+			for _, tag := range v.([]interface{}) {
+				if s := tag.(string); len(s) > 63 || !entityTagRegexp.MatchString(s) {
+					errs = append(errs, fmt.Errorf("metadata.tags: %q must be lowercase, 1-63 characters of [a-z0-9:+#] separated by -", s))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// isStringList checks if the given parsed YAML value is a list of strings
+func isStringList(value interface{}) bool {
+	xs, ok := value.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, x := range xs {
+		if _, ok := x.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedKeys returns the keys of the given parsed YAML mapping, in sorted order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasString checks if the given slice contains the given string
+func hasString(xs []string, s string) bool {
+	for _, x := range xs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
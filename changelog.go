@@ -0,0 +1,453 @@
+package acode
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// ChangelogHeader is used when a new CHANGELOG.md file is created
+const ChangelogHeader = `# Changelog
+
+All notable changes to this project will be documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/),
+and this project adheres to [Semantic Versioning](https://semver.org/spec/v2.0.0.html).
+`
+
+// Commit is a commit from the git log
+type Commit struct {
+	Hash     string
+	Subject  string
+	Body     string
+	Type     string // the conventional commit type, like "feat" or "fix", or a blank string
+	Scope    string
+	Breaking bool
+	Files    []string // the changed files, relative to the repository root
+}
+
+var (
+	conventionalCommitRegexp = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s+(.+)$`)
+	changelogVersionRegexp   = regexp.MustCompile(`^##\s+\[?([^\]\s]+)\]?`)
+)
+
+// conventionalTypeTitles are the group titles for the conventional commit types, in the order they are listed
+var conventionalTypeTitles = []struct{ Type, Title string }{
+	{"feat", "Features"},
+	{"fix", "Bug fixes"},
+	{"perf", "Performance"},
+	{"refactor", "Refactoring"},
+	{"docs", "Documentation"},
+	{"test", "Tests"},
+	{"build", "Build"},
+	{"ci", "Continuous integration"},
+	{"style", "Style"},
+	{"chore", "Chores"},
+	{"revert", "Reverts"},
+	{"", "Other"},
+}
+
+// ReadCommits reads the commits in the given range from the git log, with the oldest commit last.
+// If from is blank, all commits up to and including to are read.
+func ReadCommits(dir, from, to string) ([]Commit, error) {
+	revisions := to
+	if from != "" {
+		revisions = from + ".." + to
+	}
+	// Each commit starts with a record separator, and the fields are separated by unit separators
+	output, err := runGit(dir, "log", "--no-color", "--no-merges", "--name-only", "--format=%x1e%H%x1f%s%x1f%b%x1f", revisions)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.SplitN(record, "\x1f", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		commit := Commit{
+			Hash:    strings.TrimSpace(fields[0]),
+			Subject: strings.TrimSpace(fields[1]),
+			Body:    strings.TrimSpace(fields[2]),
+		}
+		for _, line := range strings.Split(fields[3], "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				commit.Files = append(commit.Files, line)
+			}
+		}
+		if m := conventionalCommitRegexp.FindStringSubmatch(commit.Subject); m != nil {
+			commit.Type = strings.ToLower(m[1])
+			commit.Scope = m[2]
+			commit.Breaking = m[3] == "!"
+			commit.Subject = m[4]
+		}
+		if strings.Contains(commit.Body, "BREAKING CHANGE") {
+			commit.Breaking = true
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+// CommitGroup is a titled group of commits
+type CommitGroup struct {
+	Title   string
+	Commits []Commit
+}
+
+// GroupCommits groups the commits by conventional commit type if by is "type", or by the directories
+// of the changed files if by is "package". Commits that change several packages are listed in each of them.
+func GroupCommits(commits []Commit, by string) ([]CommitGroup, error) {
+	var groups []CommitGroup
+	switch by {
+	case "", "type":
+		known := make(map[string]bool)
+		for _, t := range conventionalTypeTitles {
+			known[t.Type] = true
+		}
+		for _, t := range conventionalTypeTitles {
+			group := CommitGroup{Title: t.Title}
+			for _, commit := range commits {
+				if commit.Type == t.Type || (t.Type == "" && !known[commit.Type]) {
+					group.Commits = append(group.Commits, commit)
+				}
+			}
+			if len(group.Commits) > 0 {
+				groups = append(groups, group)
+			}
+		}
+	case "package":
+		byPackage := make(map[string][]Commit)
+		for _, commit := range commits {
+			seen := make(map[string]bool)
+			for _, file := range commit.Files {
+				pkg := filepath.ToSlash(filepath.Dir(file))
+				if !seen[pkg] {
+					seen[pkg] = true
+					byPackage[pkg] = append(byPackage[pkg], commit)
+				}
+			}
+			if len(commit.Files) == 0 {
+				byPackage["."] = append(byPackage["."], commit)
+			}
+		}
+		packages := make([]string, 0, len(byPackage))
+		for pkg := range byPackage {
+			packages = append(packages, pkg)
+		}
+		sort.Strings(packages)
+		for _, pkg := range packages {
+			groups = append(groups, CommitGroup{Title: pkg, Commits: byPackage[pkg]})
+		}
+	default:
+		return nil, fmt.Errorf("unknown changelog grouping %q, expected \"type\" or \"package\"", by)
+	}
+	return groups, nil
+}
+
+// formatCommitGroups lists the grouped commits, so that they can be used in a prompt
+func formatCommitGroups(groups []CommitGroup) string {
+	var sb strings.Builder
+	for _, group := range groups {
+		fmt.Fprintf(&sb, "\n### %s\n", group.Title)
+		for _, commit := range group.Commits {
+			short := commit.Hash
+			if len(short) > 7 {
+				short = short[:7]
+			}
+			sb.WriteString("- ")
+			if commit.Breaking {
+				sb.WriteString("BREAKING: ")
+			}
+			if commit.Scope != "" {
+				sb.WriteString(commit.Scope + ": ")
+			}
+			fmt.Fprintf(&sb, "%s (%s)\n", commit.Subject, short)
+			if commit.Body != "" {
+				for _, line := range strings.Split(commit.Body, "\n") {
+					sb.WriteString("  " + line + "\n")
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+// isTag checks if the given ref is a git tag
+func isTag(dir, ref string) bool {
+	_, err := runGit(dir, "rev-parse", "--verify", "--quiet", "refs/tags/"+ref)
+	return err == nil
+}
+
+// tagAt returns the tag that points at the given ref, or a blank string if there is none
+func tagAt(dir, ref string) string {
+	output, err := runGit(dir, "describe", "--tags", "--exact-match", ref)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// previousTag returns the latest tag before the given ref, or a blank string if there is none
+func previousTag(dir, ref string) string {
+	if isTag(dir, ref) {
+		ref += "^"
+	}
+	output, err := runGit(dir, "describe", "--tags", "--abbrev=0", ref)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// prepareChangelog reads and groups the commits between cfg.FromTag and cfg.ToTag, for use in the changelog prompt.
+// If cfg.FromTag is blank, the latest tag before cfg.ToTag is used. If cfg.ReleaseVersion is blank,
+// cfg.ToTag is used as the version if it is a tag, and "Unreleased" if not.
+func (cfg *Config) prepareChangelog(project *projectinfo.ProjectInfo) error {
+	to := cfg.ToTag
+	if to == "" {
+		// If HEAD is tagged, the release notes are for that tag, and they start at the tag before it
+		to = "HEAD"
+		if tag := tagAt(cfg.Directory, "HEAD"); tag != "" {
+			to = tag
+		}
+	}
+	from := cfg.FromTag
+	if from == "" {
+		from = previousTag(cfg.Directory, to)
+	}
+	commits, err := ReadCommits(cfg.Directory, from, to)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("there are no commits between %s and %s", from, to)
+	}
+	groups, err := GroupCommits(commits, cfg.ChangelogGroupBy)
+	if err != nil {
+		return err
+	}
+	cfg.commits = formatCommitGroups(groups)
+
+	cfg.releaseVersion = cfg.ReleaseVersion
+	cfg.releaseDate = time.Now().Format("2006-01-02")
+	if isTag(cfg.Directory, to) {
+		if cfg.releaseVersion == "" {
+			cfg.releaseVersion = strings.TrimPrefix(to, "v")
+		}
+		if output, err := runGit(cfg.Directory, "log", "-1", "--format=%cs", to); err == nil {
+			cfg.releaseDate = strings.TrimSpace(output)
+		}
+	}
+	if cfg.releaseVersion == "" {
+		cfg.releaseVersion = "Unreleased"
+	}
+
+	// The release notes are written from the commit log, not from the source code
+	cfg.limitProjectFiles(project, func(string) bool { return false })
+
+	if !cfg.Silent {
+		if from == "" {
+			log.Printf("Writing release notes for %d commit(s) up to %s.\n", len(commits), to)
+		} else {
+			log.Printf("Writing release notes for %d commit(s) between %s and %s.\n", len(commits), from, to)
+		}
+	}
+	return nil
+}
+
+// releaseHeading returns the heading for the new changelog section
+func (cfg *Config) releaseHeading() string {
+	if cfg.releaseVersion == "Unreleased" {
+		return "## [Unreleased]"
+	}
+	return fmt.Sprintf("## [%s] - %s", cfg.releaseVersion, cfg.releaseDate)
+}
+
+// changelogVersion returns the version from a "## [1.2.3] - 2006-01-02" heading, or a blank string
+func changelogVersion(line string) string {
+	if m := changelogVersionRegexp.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// PrependChangelogSection adds a new version section to an existing changelog, before the previous versions,
+// and returns the new changelog. If the existing changelog is blank, a Keep a Changelog header is added first.
+// An existing section for the same version is replaced where it is. When a new version is added, it takes the place
+// of the "Unreleased" section, if there is one. The entries of a replaced "Unreleased" section that are not in the
+// new section are kept, under the same category, so that hand-written entries are not lost.
+func PrependChangelogSection(existing, section string) string {
+	section = strings.TrimSpace(section) + "\n"
+	if strings.TrimSpace(existing) == "" {
+		return ChangelogHeader + "\n" + section
+	}
+	version := ""
+	if lines := strings.SplitN(section, "\n", 2); len(lines) > 0 {
+		version = changelogVersion(lines[0])
+	}
+	preamble, sections := splitChangelog(existing)
+	same, unreleased := -1, -1
+	for i, s := range sections {
+		v := changelogVersion(strings.SplitN(s, "\n", 2)[0])
+		if unreleased < 0 && strings.EqualFold(v, "Unreleased") {
+			unreleased = i
+		}
+		if same < 0 && v != "" && strings.EqualFold(v, version) {
+			same = i
+		}
+	}
+	switch {
+	case same >= 0:
+		if same == unreleased {
+			section = mergeChangelogEntries(section, sections[same])
+		}
+		sections[same] = section
+	case unreleased >= 0:
+		// The unreleased changes are released in the new version
+		sections[unreleased] = mergeChangelogEntries(section, sections[unreleased])
+	default:
+		sections = append([]string{section}, sections...)
+	}
+	var sb strings.Builder
+	if strings.TrimSpace(preamble) != "" {
+		sb.WriteString(strings.TrimRight(preamble, "\n") + "\n\n")
+	}
+	for i, s := range sections {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(strings.TrimRight(s, "\n") + "\n")
+	}
+	return sb.String()
+}
+
+// splitChangelog splits a changelog into the text before the first "## " heading, and the sections that start with one
+func splitChangelog(changelog string) (string, []string) {
+	var (
+		preamble strings.Builder
+		sections []string
+	)
+	for _, line := range strings.SplitAfter(changelog, "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			sections = append(sections, line)
+		case len(sections) == 0:
+			preamble.WriteString(line)
+		default:
+			sections[len(sections)-1] += line
+		}
+	}
+	return preamble.String(), sections
+}
+
+// changelogEntries returns the entries of a changelog section by category, and the "### " category headings in order.
+// An entry is a line, together with the indented lines that follow it. Entries before the first category are under "".
+func changelogEntries(section string) ([]string, map[string][]string) {
+	var (
+		headings []string
+		entries  = make(map[string][]string)
+		category string
+	)
+	for _, line := range splitLines(section) {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "## ") || trimmed == "":
+		case strings.HasPrefix(line, "### "):
+			category = strings.ToLower(strings.TrimSpace(line[4:]))
+			headings = append(headings, line)
+		case (line[0] == ' ' || line[0] == '\t') && len(entries[category]) > 0:
+			last := len(entries[category]) - 1
+			entries[category][last] += "\n" + line
+		default:
+			entries[category] = append(entries[category], line)
+		}
+	}
+	return headings, entries
+}
+
+// mergeChangelogEntries adds the entries from the old section that are missing in the new section to the new section,
+// at the end of the same category, or in a new category at the end of the section
+func mergeChangelogEntries(section, old string) string {
+	oldHeadings, oldEntries := changelogEntries(old)
+	_, newEntries := changelogEntries(section)
+	present := make(map[string]bool)
+	for _, categoryEntries := range newEntries {
+		for _, entry := range categoryEntries {
+			present[strings.TrimSpace(strings.SplitN(entry, "\n", 2)[0])] = true
+		}
+	}
+	missing := make(map[string][]string)
+	for category, categoryEntries := range oldEntries {
+		for _, entry := range categoryEntries {
+			if !present[strings.TrimSpace(strings.SplitN(entry, "\n", 2)[0])] {
+				missing[category] = append(missing[category], entry)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return section
+	}
+	var (
+		lines    []string
+		category string
+	)
+	trimBlank := func() {
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+	}
+	addMissing := func(heading string) {
+		if len(missing[category]) == 0 {
+			return
+		}
+		trimBlank()
+		if heading != "" {
+			lines = append(lines, "", heading, "")
+		}
+		lines = append(lines, missing[category]...)
+		delete(missing, category)
+	}
+	for _, line := range splitLines(section) {
+		if strings.HasPrefix(line, "### ") {
+			if len(missing[category]) > 0 {
+				addMissing("")
+				lines = append(lines, "")
+			}
+			category = strings.ToLower(strings.TrimSpace(line[4:]))
+		}
+		lines = append(lines, line)
+	}
+	addMissing("")
+	// The entries before the first category in the old section, and the categories that are not in the new section
+	category = ""
+	addMissing("")
+	for _, heading := range oldHeadings {
+		category = strings.ToLower(strings.TrimSpace(heading[4:]))
+		addMissing(heading)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// changelogSection extracts the new changelog section from the AI response, and makes sure it starts with the release heading
+func (cfg *Config) changelogSection(response string) string {
+	response = strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response)))
+	if i := strings.Index(response, "### "); i >= 0 && !strings.HasPrefix(response, "## ") {
+		response = response[i:]
+	}
+	if strings.HasPrefix(response, "## ") {
+		// Use the heading with the correct version and date
+		if i := strings.Index(response, "\n"); i >= 0 {
+			response = strings.TrimSpace(response[i:])
+		} else {
+			response = ""
+		}
+	}
+	return cfg.releaseHeading() + "\n\n" + response + "\n"
+}
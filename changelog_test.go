@@ -0,0 +1,51 @@
+package acode
+
+import "testing"
+
+func TestPrependChangelogSection(t *testing.T) {
+	const header = "# Changelog\n\nNotes.\n\n"
+	tests := []struct {
+		name     string
+		existing string
+		section  string
+		want     string
+	}{
+		{
+			name:     "new changelog",
+			existing: "",
+			section:  "## [1.0.0] - 2026-01-01\n\n### Added\n\n- A\n",
+			want:     ChangelogHeader + "\n## [1.0.0] - 2026-01-01\n\n### Added\n\n- A\n",
+		},
+		{
+			name:     "new version before the previous ones",
+			existing: header + "## [1.0.0] - 2026-01-01\n\n- A\n",
+			section:  "## [1.1.0] - 2026-02-01\n\n- B\n",
+			want:     header + "## [1.1.0] - 2026-02-01\n\n- B\n\n## [1.0.0] - 2026-01-01\n\n- A\n",
+		},
+		{
+			name:     "an older version is replaced where it is",
+			existing: header + "## [1.1.0] - 2026-02-01\n\n- B\n\n## [1.0.0] - 2026-01-01\n\n- A\n",
+			section:  "## [1.0.0] - 2026-01-01\n\n- A, regenerated\n",
+			want:     header + "## [1.1.0] - 2026-02-01\n\n- B\n\n## [1.0.0] - 2026-01-01\n\n- A, regenerated\n",
+		},
+		{
+			name:     "hand-written unreleased entries are kept in the release",
+			existing: header + "## [Unreleased]\n\n### Added\n\n- Generated entry\n- Hand-written entry\n  with two lines\n\n### Security\n\n- Hand-written fix\n\n## [1.0.0] - 2026-01-01\n\n- A\n",
+			section:  "## [1.1.0] - 2026-02-01\n\n### Added\n\n- Generated entry\n\n### Fixed\n\n- Generated fix\n",
+			want:     header + "## [1.1.0] - 2026-02-01\n\n### Added\n\n- Generated entry\n- Hand-written entry\n  with two lines\n\n### Fixed\n\n- Generated fix\n\n### Security\n\n- Hand-written fix\n\n## [1.0.0] - 2026-01-01\n\n- A\n",
+		},
+		{
+			name:     "the unreleased section is regenerated with the hand-written entries",
+			existing: header + "## [Unreleased]\n\n### Added\n\n- Hand-written entry\n",
+			section:  "## [Unreleased]\n\n### Added\n\n- Generated entry\n",
+			want:     header + "## [Unreleased]\n\n### Added\n\n- Generated entry\n- Hand-written entry\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PrependChangelogSection(tt.existing, tt.section); got != tt.want {
+				t.Errorf("PrependChangelogSection() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package acode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/projectinfo"
+)
+
+var (
+	// ChatHistoryShare is the share of the token budget that the conversation history may use before older turns are summarized
+	ChatHistoryShare = 0.25
+	// ChatKeepTurns is the number of recent turns that are always kept verbatim, instead of being summarized
+	ChatKeepTurns = 2
+	// ChatMaxFiles is the maximum number of files that are pulled into the context for each question
+	ChatMaxFiles = 12
+	// ChatMaxSegments is the maximum number of retrieved file segments that are pulled into the context for each question
+	ChatMaxSegments = 24
+)
+
+// citationRegexp matches file citations like "config.go:42" or "cmd/main.go:10-20"
+var citationRegexp = regexp.MustCompile(`([\w./-]+\.\w+):(\d+)(?:-(\d+))?`)
+
+// chatStopWords are common words that are not used for finding relevant files
+var chatStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true, "all": true,
+	"can": true, "how": true, "what": true, "where": true, "when": true, "why": true, "which": true, "who": true,
+	"does": true, "this": true, "that": true, "with": true, "from": true, "into": true, "there": true, "their": true,
+	"have": true, "has": true, "was": true, "were": true, "will": true, "would": true, "should": true, "could": true,
+	"about": true, "code": true, "file": true, "files": true, "project": true, "function": true, "used": true, "use": true,
+}
+
+// ChatTurn is a question and the answer to it
+type ChatTurn struct {
+	Question string
+	Answer   string
+}
+
+// Chat is a conversation about a project, where the relevant files are pulled into the context for each question
+type Chat struct {
+	cfg          *Config
+	project      *projectinfo.ProjectInfo
+	prompt       string     // the prompt template for each turn
+	Summary      string     // a summary of the turns that are no longer kept verbatim
+	History      []ChatTurn // the most recent turns
+	TotalUSDCost float64
+}
+
+// NewChat starts a new conversation about the given project. The chat prompt is cfg.InitialPrompt if the
+// configuration was prepared for OpChat with a custom prompt, or else the default chat prompt.
+func (cfg *Config) NewChat(project *projectinfo.ProjectInfo) *Chat {
+	prompt := cfg.InitialPrompt
+	if cfg.OpType != OpChat || strings.TrimSpace(prompt) == "" {
+		prompt = GetInitialPrompt(OpChat)
+	}
+	return &Chat{cfg: cfg, project: project, prompt: prompt}
+}
+
+// questionTerms returns the lowercase words in the question that can be used for finding relevant files
+func questionTerms(question string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len(word) < 3 || chatStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// relevantFiles returns the project files that are most relevant for the question, the most relevant first.
+// Files that are mentioned by name in the question come first, then files where the words from the question
+// occur the most, relative to the file size.
+func (c *Chat) relevantFiles(question string) []projectinfo.FileInfo {
+	type scoredFile struct {
+		file  projectinfo.FileInfo
+		score float64
+	}
+	var (
+		scored []scoredFile
+		terms  = questionTerms(question)
+		lower  = strings.ToLower(question)
+	)
+	for _, file := range c.project.AllFiles() {
+		rel := strings.ToLower(c.cfg.relativeProjectPath(file.Path))
+		contents := strings.ToLower(file.Contents)
+		score := 0.0
+		if strings.Contains(lower, rel) {
+			score += 100
+		}
+		for _, term := range terms {
+			if strings.Contains(rel, term) {
+				score += 5
+			}
+			if n := strings.Count(contents, term); n > 0 {
+				score += 1 + float64(n)/float64(1+len(contents)/1000)
+			}
+		}
+		if score > 0 {
+			scored = append(scored, scoredFile{file, score})
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].score > scored[j].score })
+	var files []projectinfo.FileInfo
+	for i := 0; i < len(scored) && i < ChatMaxFiles; i++ {
+		files = append(files, scored[i].file)
+	}
+	return files
+}
+
+// numberedRelevantFiles returns the most relevant files for the question, with line numbers,
+// leaving out files that do not fit within the token budget on their own
+func (c *Chat) numberedRelevantFiles(question string, budget int) []projectinfo.FileInfo {
+	var numbered []projectinfo.FileInfo
+	for _, file := range c.relevantFiles(question) {
+		file.Path = c.cfg.relativeProjectPath(file.Path)
+		file.Contents = withLineNumbers(file.Contents)
+		file.Contributors = nil
+		if projectinfo.CountTokens(file.Contents) <= budget {
+			numbered = append(numbered, file)
+		}
+	}
+	return numbered
+}
+
+// withLineNumbers prefixes each line with its line number, so that the AI can cite lines
+func withLineNumbers(contents string) string {
+	lines := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	width := len(strconv.Itoa(len(lines)))
+	var sb strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&sb, "%*d| %s\n", width, i+1, line)
+	}
+	return sb.String()
+}
+
+// formatHistory returns the summary and the recent turns, for use in the prompt
+func (c *Chat) formatHistory() string {
+	var sb strings.Builder
+	if c.Summary != "" {
+		sb.WriteString("\n\nSummary of the earlier conversation:\n" + c.Summary + "\n")
+	}
+	for _, turn := range c.History {
+		fmt.Fprintf(&sb, "\nQuestion: %s\nAnswer: %s\n", turn.Question, turn.Answer)
+	}
+	if sb.Len() == 0 {
+		return "\n\n(This is the first question.)\n"
+	}
+	return sb.String()
+}
+
+// summarizeHistory summarizes the oldest turns, together with the previous summary, until the history fits
+// within ChatHistoryShare of the token budget. The most recent ChatKeepTurns turns are always kept.
+func (c *Chat) summarizeHistory(status io.Writer) error {
+	budget := int(float64(c.cfg.Model.MaxTokens) * ChatHistoryShare)
+	if projectinfo.CountTokens(c.formatHistory()) <= budget || len(c.History) <= ChatKeepTurns {
+		return nil
+	}
+	old := c.History[:len(c.History)-ChatKeepTurns]
+	var sb strings.Builder
+	for _, turn := range old {
+		fmt.Fprintf(&sb, "\nQuestion: %s\nAnswer: %s\n", turn.Question, turn.Answer)
+	}
+	prompt, err := c.cfg.BuildPrompt(GetChatSummaryPrompt(), TemplateData{
+		History:          "\n\n" + c.Summary + "\n",
+		PreviousAIAnswer: "\n" + sb.String(),
+	})
+	if err != nil {
+		return err
+	}
+	summary, usdCost, err := c.cfg.postAndReport(status, "[summary] ", prompt, c.cfg.CountPromptTokens(prompt))
+	c.TotalUSDCost += usdCost
+	if err != nil {
+		return fmt.Errorf("could not summarize the conversation: %v", err)
+	}
+	c.Summary = strings.TrimSpace(summary)
+	c.History = append([]ChatTurn(nil), c.History[len(old):]...)
+	return nil
+}
+
+// Ask answers a question about the project. The most relevant files are pulled into the context, with line numbers,
+// and the answer cites file paths and lines. Older turns are summarized when the history grows too large.
+func (c *Chat) Ask(status io.Writer, question string) (string, error) {
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return "", fmt.Errorf("the question is empty")
+	}
+	defer c.cfg.pruneCacheIfWritten()
+	if err := c.summarizeHistory(status); err != nil {
+		fmt.Fprintf(status, "Warning: %v\n", err)
+	}
+	data := TemplateData{
+		ReadmeContents: "\n\n" + FileContents(c.project, "README.md") + "\n",
+		History:        c.formatHistory(),
+		Question:       question,
+		SourceCode:     "\n\n[]\n",
+	}
+	barePrompt, err := c.cfg.BuildPrompt(c.prompt, data)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		numbered  []projectinfo.FileInfo
+		maxTokens = c.cfg.Model.MaxTokens
+		budget    = int(float64(maxTokens-projectinfo.CountTokens(barePrompt)) / PromptMargin)
+	)
+	// Use the best matching file segments from the search index and the vector store, if possible
+	if results, err := c.cfg.Retrieve(status, c.project, question, ChatMaxSegments, budget); err != nil {
+		fmt.Fprintf(status, "Warning: %v\n", err)
+	} else if len(results) > 0 {
+		data.SourceCode = "\n" + FormatSearchResults(results)
+	}
+	if data.SourceCode == "\n\n[]\n" {
+		// Use Chunk for fitting as many of the relevant files as possible into the rest of the token budget
+		numbered = c.numberedRelevantFiles(question, budget)
+	}
+	if len(numbered) > 0 {
+		c.cfg.Model.MaxTokens = budget
+		chunks, err := Chunk(c.cfg, &projectinfo.ProjectInfo{SourceFiles: numbered}, true, false)
+		c.cfg.Model.MaxTokens = maxTokens
+		if err != nil {
+			return "", err
+		}
+		if len(chunks) > 0 {
+			data.SourceCode = "\n\n" + chunks[0] + "\n"
+		}
+	}
+	prompt, err := c.cfg.BuildPrompt(c.prompt, data)
+	if err != nil {
+		return "", err
+	}
+	answer, usdCost, err := c.cfg.postAndReport(status, "", prompt, c.cfg.CountPromptTokens(prompt))
+	c.TotalUSDCost += usdCost
+	if err != nil {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	for _, warning := range c.checkCitations(answer) {
+		fmt.Fprintf(status, "Warning: %s\n", warning)
+	}
+	c.History = append(c.History, ChatTurn{Question: question, Answer: answer})
+	return answer, nil
+}
+
+// checkCitations returns a warning for each cited file that is not in the project, or line that is past the end of the file
+func (c *Chat) checkCitations(answer string) []string {
+	var warnings []string
+	for _, m := range citationRegexp.FindAllStringSubmatch(answer, -1) {
+		var found *projectinfo.FileInfo
+		for _, file := range c.project.AllFiles() {
+			if sameFile(c.cfg.relativeProjectPath(file.Path), m[1]) {
+				found = &file
+				break
+			}
+		}
+		if found == nil {
+			warnings = append(warnings, fmt.Sprintf("the answer cites %s, which is not in the project", m[1]))
+			continue
+		}
+		lines := strings.Count(found.Contents, "\n") + 1
+		for _, s := range m[2:] {
+			if n, err := strconv.Atoi(s); err == nil && n > lines {
+				warnings = append(warnings, fmt.Sprintf("the answer cites %s, but %s only has %d lines", m[0], m[1], lines))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// Run reads questions from in, one per line, and writes the answers to out, until the input ends
+// or "exit" or "quit" is given. The total cost is written to status at the end.
+func (c *Chat) Run(status io.Writer, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintf(out, "Ask a question about %s, or type \"exit\" to quit.\n> ", c.project.Name)
+	for scanner.Scan() {
+		question := strings.TrimSpace(scanner.Text())
+		if question == "exit" || question == "quit" {
+			break
+		}
+		if question != "" {
+			answer, err := c.Ask(status, question)
+			if err != nil {
+				fmt.Fprintf(out, "Error: %v\n", err)
+				if !c.cfg.Silent {
+					log.Printf("Error: %v\n", err)
+				}
+			} else {
+				fmt.Fprintf(out, "\n%s\n\n", answer)
+			}
+		}
+		fmt.Fprint(out, "> ")
+	}
+	fmt.Fprintf(status, "Approximate cost for the conversation: $%.2f\n", c.TotalUSDCost)
+	return scanner.Err()
+}
@@ -0,0 +1,20 @@
+package acode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewChatPrompt(t *testing.T) {
+	cfg := NewConfig(&Model{}, &Model{})
+	cfg.OpType = OpFindBug
+	cfg.InitialPrompt = GetInitialPrompt(OpFindBug)
+	if chat := cfg.NewChat(nil); !strings.Contains(chat.prompt, "{{.Question}}") {
+		t.Errorf("NewChat() used %q, want the chat prompt", chat.prompt)
+	}
+	cfg.OpType = OpChat
+	cfg.InitialPrompt = "Answer {{.Question}} briefly."
+	if chat := cfg.NewChat(nil); chat.prompt != cfg.InitialPrompt {
+		t.Errorf("NewChat() used %q, want the custom chat prompt", chat.prompt)
+	}
+}
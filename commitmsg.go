@@ -0,0 +1,180 @@
+package acode
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/projectinfo"
+)
+
+const (
+	// CommitSubjectWidth is the maximum length of the subject line of a generated commit message
+	CommitSubjectWidth = 72
+	// CommitBodyWidth is the column where the body of a generated commit message is wrapped
+	CommitBodyWidth = 72
+)
+
+// hookMarker identifies hooks that were installed by acode, so that they can be replaced without asking
+const hookMarker = "# installed by acode"
+
+// commitConventions describes the supported commit message conventions, for use in the prompt
+var commitConventions = map[string]string{
+	"conventional": `Follow the Conventional Commits specification: the subject line must be "type(scope): description", where type is one of feat, fix, docs, style, refactor, perf, test, build, ci, chore or revert, and the scope is optional. Add "!" after the type or scope for breaking changes, and describe them in a "BREAKING CHANGE: " paragraph at the end of the body.`,
+	"plain":        `Write the subject line as a short summary in the imperative mood, starting with a capital letter, like "Add support for custom key bindings".`,
+}
+
+// prepareCommitMessage reads the staged changes, for use in the commit message prompt
+func (cfg *Config) prepareCommitMessage(project *projectinfo.ProjectInfo) error {
+	if _, ok := commitConventions[cfg.commitConvention()]; !ok {
+		return fmt.Errorf("unknown commit message convention %q, expected \"conventional\" or \"plain\"", cfg.CommitConvention)
+	}
+	diff, err := runGit(cfg.Directory, "diff", "--cached", "--no-color", "--relative", "--", ".")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(diff) == "" {
+		return fmt.Errorf("there are no staged changes")
+	}
+	cfg.diff = diff
+
+	// The commit message is written from the staged changes, not from the rest of the source code
+	cfg.limitProjectFiles(project, func(string) bool { return false })
+
+	if !cfg.Silent {
+		log.Printf("Writing a commit message for %d line(s) of staged changes.\n", strings.Count(diff, "\n"))
+	}
+	return nil
+}
+
+// commitConvention returns the selected commit message convention, "conventional" by default
+func (cfg *Config) commitConvention() string {
+	if cfg.CommitConvention == "" {
+		return "conventional"
+	}
+	return strings.ToLower(cfg.CommitConvention)
+}
+
+// wrapText wraps each paragraph of the text at the given width. List items get a hanging indent,
+// and indented lines, like code, are kept as they are.
+func wrapText(text string, width int) string {
+	var (
+		out   []string
+		words []string
+		first string // the prefix of the first line of the current paragraph or list item
+		rest  string // the prefix of the following lines
+	)
+	flush := func() {
+		if len(words) == 0 {
+			return
+		}
+		line := first
+		for _, word := range words {
+			if line != first && len(line)+1+len(word) > width {
+				out = append(out, line)
+				line = rest
+			}
+			if line == first || line == rest {
+				line += word
+			} else {
+				line += " " + word
+			}
+		}
+		out = append(out, line)
+		words = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+			out = append(out, "")
+		case strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t"):
+			flush()
+			out = append(out, strings.TrimRight(line, " \t"))
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			flush()
+			first, rest = trimmed[:2], "  "
+			words = strings.Fields(trimmed[2:])
+		default:
+			if len(words) == 0 {
+				first, rest = "", ""
+			}
+			words = append(words, strings.Fields(trimmed)...)
+		}
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
+
+// FormatCommitMessage cleans up a commit message from the AI, so that it has a single subject line
+// of at most CommitSubjectWidth characters, a blank line and a body that is wrapped at CommitBodyWidth.
+func FormatCommitMessage(response string) string {
+	response = strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response)))
+	lines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+	subject := strings.TrimSpace(lines[0])
+	subject = strings.TrimSpace(strings.TrimPrefix(subject, "Subject:"))
+	subject = strings.Trim(subject, "\"`")
+	subject = strings.TrimRight(subject, ".")
+	if len(subject) > CommitSubjectWidth {
+		if i := strings.LastIndex(subject[:CommitSubjectWidth], " "); i > 0 {
+			subject = subject[:i]
+		} else {
+			subject = subject[:CommitSubjectWidth]
+		}
+	}
+	body := strings.TrimSpace(strings.Join(lines[1:], "\n"))
+	body = strings.TrimSpace(strings.TrimPrefix(body, "Body:"))
+	if body == "" {
+		return subject + "\n"
+	}
+	return subject + "\n\n" + wrapText(body, CommitBodyWidth) + "\n"
+}
+
+// InstallCommitMsgHook installs a prepare-commit-msg hook in the git repository that contains cfg.Directory.
+// The hook runs the given command, which should output a commit message, and places the message above the
+// comments that git adds to the editor buffer. It does nothing when a message is given with -m, or for merges,
+// squashes and amends, and it never stops the commit if the command fails.
+// An existing hook that was not installed by acode is only replaced if confirmed.
+func (cfg *Config) InstallCommitMsgHook(command string) (string, error) {
+	hooksDir, err := runGit(cfg.Directory, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	hooksDir = strings.TrimSpace(hooksDir)
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(cfg.Directory, hooksDir)
+	}
+	hookFilename := filepath.Join(hooksDir, "prepare-commit-msg")
+	if files.Exists(hookFilename) {
+		existing, err := os.ReadFile(hookFilename)
+		if err != nil {
+			return "", fmt.Errorf("could not read %s: %v", hookFilename, err)
+		}
+		if !strings.Contains(string(existing), hookMarker) && !cfg.confirm(hookFilename+" already exists. Replace it?", string(existing)) {
+			return "", fmt.Errorf("did not replace %s: %w", hookFilename, ErrNotConfirmed)
+		}
+	}
+	script := `#!/bin/sh
+` + hookMarker + `
+# $1 is the commit message file, $2 is the source of the message, if any
+[ -n "$2" ] && exit 0
+msg=$(` + command + `) || exit 0
+[ -z "$msg" ] && exit 0
+{ printf '%s\n\n' "$msg"; cat "$1"; } > "$1.acode" && mv "$1.acode" "$1"
+exit 0
+`
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return "", fmt.Errorf("could not create %s: %v", hooksDir, err)
+	}
+	if err := cfg.writeOutputFile(hookFilename, []byte(script)); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", hookFilename, err)
+	}
+	if err := os.Chmod(hookFilename, 0755); err != nil {
+		return "", err
+	}
+	return hookFilename, nil
+}
@@ -21,7 +21,9 @@ type Config struct {
 	FixPrompt                  string
 	ConfidencePrompt           string
 	OutputFilename             string
+	OutputFormat               string // "text", "sarif", "checkstyle", "junit" or "github", or blank to select by OutputFilename, for operations that report findings
 	Force                      bool
+	Confirmer                  Confirmer // asked before existing files are changed, unless Force is set. If nil, nothing is changed.
 	Silent                     bool
 	OutputPrompt               bool
 	Version                    bool
@@ -31,6 +33,64 @@ type Config struct {
 	ExcludeSources             bool
 	AlsoOutputFixAndConfidence bool
 	Timeout                    time.Duration
+	VerifyFixes                bool     // build and test the fixes in a scratch copy, and let the outcome affect the confidence
+	BuildCommands              []string // commands that must succeed for a fix to be verified, split at whitespace and run without a shell
+	TestCommands               []string // commands that must succeed for a fix to not fail the tests, split at whitespace and run without a shell
+	VerifyTimeout              time.Duration
+	ValidationRetries          int           // how many times to ask for a corrected file, if the generated file is not valid
+	TargetFiles                []TargetFile  // the files to generate with OpGenAnyFile
+	CoverProfile               string        // a coverprofile from "go test -coverprofile", used by OpGenTest for finding uncovered functions
+	RunGeneratedTests          bool          // compile the tests that are generated by OpGenTest, and remove the ones that do not compile
+	BaseRef                    string        // the git ref that OpReview compares HEAD with
+	FromTag                    string        // the tag after which the commits for OpGenChangelog start, the default is the previous tag
+	ToTag                      string        // the tag or ref where the commits for OpGenChangelog end, the default is HEAD
+	ReleaseVersion             string        // the version for the new changelog section, the default is taken from ToTag
+	ChangelogGroupBy           string        // "type" for grouping commits by conventional commit type, or "package"
+	CommitConvention           string        // "conventional" or "plain", for OpGenCommitMsg
+	DiagramFormat              string        // "mermaid" or "dot", for OpGenDiagram
+	DryRun                     bool          // show the changes as a diff instead of writing them, for OpGenGodoc
+	IndexFile                  string        // where the search index is stored, the default is .acode/index.json in the project directory
+	Embedder                   Embedder      // used for semantic retrieval, together with the search index, if set
+	VectorStoreFile            string        // where the embeddings are stored, the default is .acode/vectors.json in the project directory
+	SemanticWeight             float64       // the weight of the semantic score when combining it with the lexical score, from 0 to 1
+	RetrievalQuery             string        // if set, only the file segments that are most relevant for this query are sent, instead of all files
+	ChangedSince               string        // only process the files that have changed since this git ref, for operations that report findings
+	Uncommitted                bool          // only process the files with uncommitted changes, for operations that report findings
+	IncludeDependents          bool          // in incremental mode, also process the Go packages that directly import a changed package
+	CacheDir                   string        // where responses are cached, the default is .acode/cache in the project directory
+	CacheTTL                   time.Duration // how long cached responses are used, the default is DefaultCacheTTL
+	CacheMaxSize               int64         // the maximum total size of the cache in bytes, the default is DefaultCacheMaxSize
+	NoCache                    bool          // neither use nor store cached responses
+	RefreshCache               bool          // do not use cached responses, but store the new ones
+	Journal                    bool          // record the processed chunks in a run journal, so that the run can be resumed if it is interrupted
+	RunID                      string        // identifies the run journal, the default is a timestamp and random digits for new runs
+	Resume                     bool          // resume the run with RunID, or the latest unfinished run, skipping the chunks that were processed
+	JournalDir                 string        // where the run journals are stored, the default is .acode/runs in the project directory
+	WatchInterval              time.Duration // how often the files are checked for changes in watch mode, the default is DefaultWatchInterval
+	WatchDebounce              time.Duration // how long the files must be left unchanged before they are analyzed in watch mode
+	WatchRegenerate            bool          // in watch mode, regenerate the README for the whole project on every change, which costs a full run each time
+	EstimateCost               bool          // only build the prompts and estimate the tokens and the cost for each model, without sending anything
+	MergeReadme                bool          // merge a generated README.md into an existing one, section by section, instead of overwriting it
+	BackupDir                  string        // where backups of overwritten files are placed, in their relative directories, the default is .acode/backups in Directory
+	BackupRetention            int           // how many backups to keep per file, 0 disables backups
+	BackupMaxAge               time.Duration // backups older than this are removed, 0 means no age limit
+	outputTempName             string        // the temporary file that is written to by InitializeOutputFile, until CloseOutputFile is called
+	diff                       string        // the diff that is reviewed by OpReview
+	commits                    string        // the grouped commits that OpGenChangelog writes release notes for
+	releaseVersion             string        // the version of the new changelog section
+	releaseDate                string        // the date of the new changelog section
+	checklist                  string        // the language specific checklists for OpSecurityAudit
+	searchIndex                *SearchIndex  // opened by openRetrieval
+	vectorStore                *VectorStore  // opened by openRetrieval, if there is an Embedder
+	retrievalOpened            bool
+	journal                    *RunJournal       // the journal of the current run, opened by Process
+	lastModelName              string            // the model that gave the last response in postAndReport
+	pendingResponses           []pendingResponse // the responses that are cached once the generated file has been validated
+	cacheWritten               bool              // set when a response has been cached since the cache was last pruned
+	watching                   bool              // set while Watch is running
+	skippedFiles               []string          // the files that were skipped by the incremental mode
+	skippedTokens              int               // the estimated number of tokens in the skipped files
+	analyzedFiles              []string          // the files that were sent for analysis by Process, relative to Directory
 }
 
 // NewConfig initializes a new Config with default settings and default prompts
@@ -42,6 +102,13 @@ func NewConfig(defaultModel, fallbackModel *Model) *Config {
 	cfg.Model.Name = env.Str("MODELNAME", cfg.Model.Name)
 	cfg.Model.MaxTokens = env.Int("MAXTOKENS", cfg.Model.MaxTokens)
 	cfg.Timeout = 2 * time.Minute
+	cfg.BuildCommands = []string{"go build ./..."}
+	cfg.TestCommands = []string{"go test ./..."}
+	cfg.VerifyTimeout = 10 * time.Minute
+	cfg.ValidationRetries = 3
+	cfg.MergeReadme = true
+	cfg.BackupRetention = 10
+	cfg.SemanticWeight = 0.5
 	cfg.Directory = "." // the default value
 	return &cfg
 }
@@ -52,11 +119,13 @@ func (cfg *Config) configureCommonSettings(customInitialPrompt, customFixPrompt,
 	cfg.OpType = opType
 
 	switch cfg.OpType {
-	case OpGenReadme, OpGenAPI, OpGenDoc, OpGenAnyFile:
+	case OpGenReadme, OpGenAPI, OpGenDoc, OpGenAnyFile, OpChat:
 		cfg.IncludeConfAndDoc = true
 	case OpGenCatalog:
 		cfg.IncludeConfAndDoc = true
 		cfg.ExcludeSources = true
+	case OpGenChangelog, OpGenCommitMsg:
+		cfg.ExcludeSources = true
 	}
 
 	// Set custom or default prompts
@@ -81,6 +150,8 @@ func (cfg *Config) configureCommonSettings(customInitialPrompt, customFixPrompt,
 	return nil
 }
 
+// GatherSources configures the given operation and reads the project files in cfg.Directory.
+// If no operation is given and the project is an API server, OpGenAPI is used, not OpGenOpenAPI.
 func (cfg *Config) GatherSources(customInitialPrompt, customFixPrompt, customConfidencePrompt string, opType OperationType) (*projectinfo.ProjectInfo, error) {
 	err := cfg.configureCommonSettings(customInitialPrompt, customFixPrompt, customConfidencePrompt, opType)
 	if err != nil {
@@ -101,12 +172,18 @@ func (cfg *Config) GatherSources(customInitialPrompt, customFixPrompt, customCon
 		return nil, err
 	}
 
+	// API servers get prose API documentation by default. OpGenOpenAPI is never selected automatically,
+	// since it writes a specification file instead, so it must be chosen explicitly.
 	if cfg.OpType == 0 {
 		if project.APIServer {
 			cfg.OpType = OpGenAPI
 		}
 	}
 
+	if err := cfg.prepareOperation(&project); err != nil {
+		return nil, err
+	}
+
 	return &project, nil
 }
 
@@ -136,7 +213,9 @@ func (cfg *Config) ReactToConfigAndReadSources(args []string, customInitialPromp
 		opType = OpGenCatalog
 	} else if apidoc {
 		opType = OpGenAPI
-	} // OpGenAnyFile is not added here yet, on purpose
+	} else if len(cfg.TargetFiles) > 0 {
+		opType = OpGenAnyFile
+	}
 
 	err := cfg.configureCommonSettings(customInitialPrompt, customFixPrompt, customConfidencePrompt, opType)
 	if err != nil {
@@ -153,12 +232,18 @@ func (cfg *Config) ReactToConfigAndReadSources(args []string, customInitialPromp
 		project.Name = filepath.Base(inputDirectory)
 	}
 
+	// API servers get prose API documentation by default. OpGenOpenAPI is never selected automatically,
+	// since it writes a specification file instead, so it must be chosen explicitly.
 	if cfg.OpType == 0 {
 		if project.APIServer {
 			cfg.OpType = OpGenAPI
 		}
 	}
 
+	if err := cfg.prepareOperation(&project); err != nil {
+		return nil, err
+	}
+
 	if cfg.OutputFilename == "" {
 		// Set output file based on the operation type
 		cfg.OutputFilename = GetDefaultFilename(cfg.OpType)
@@ -167,18 +252,85 @@ func (cfg *Config) ReactToConfigAndReadSources(args []string, customInitialPromp
 	return &project, nil
 }
 
-// InitializeOutputFile opens the output file based on configuration
-func (cfg *Config) InitializeOutputFile() {
+// prepareOperation collects the extra information that some operations need, after the project files have been read
+func (cfg *Config) prepareOperation(project *projectinfo.ProjectInfo) error {
+	// The backups, caches and indexes are not part of the project
+	cfg.limitProjectFiles(project, func(rel string) bool {
+		return !inAcodeDirectory(rel)
+	})
+
+	if cfg.incrementalMode() {
+		// A document generated from only the changed files would replace the document for the whole project
+		if !reportsFindings(cfg.OpType) {
+			return fmt.Errorf("incremental mode can only be used with operations that report findings")
+		}
+		if err := cfg.limitToChangedFiles(project); err != nil {
+			return err
+		}
+	}
+	switch cfg.OpType {
+	case OpReview:
+		return cfg.prepareReview(project)
+	case OpGenChangelog:
+		return cfg.prepareChangelog(project)
+	case OpGenCommitMsg:
+		return cfg.prepareCommitMessage(project)
+	case OpSecurityAudit:
+		return cfg.prepareSecurityAudit(project)
+	case OpGenOpenAPI:
+		return cfg.prepareOpenAPI(project)
+	}
+	return nil
+}
+
+// InitializeOutputFile opens the output file based on configuration.
+// A temporary file next to the output file is written to, and it replaces the output file when CloseOutputFile is called.
+func (cfg *Config) InitializeOutputFile() error {
 	if cfg.OutputFilename == "-" || cfg.OutputFilename == "" {
 		cfg.Output = os.Stdout
-	} else {
-		var err error
-		cfg.Output, err = os.OpenFile(cfg.OutputFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to open output file %s: %v\n", cfg.OutputFilename, err)
-			os.Exit(1)
-		}
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(cfg.OutputFilename), "."+filepath.Base(cfg.OutputFilename)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to open output file %s: %v", cfg.OutputFilename, err)
+	}
+	cfg.Output = f
+	cfg.outputTempName = f.Name()
+	return nil
+}
+
+// CloseOutputFile closes the output file that was opened by InitializeOutputFile. The previous version of the output file
+// is backed up, and then replaced by the new one. If discard is true, the new output is thrown away instead.
+func (cfg *Config) CloseOutputFile(discard bool) error {
+	if cfg.outputTempName == "" {
+		return nil
+	}
+	tempName := cfg.outputTempName
+	cfg.outputTempName = ""
+	if err := cfg.Output.Close(); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("failed to close output file %s: %v", cfg.OutputFilename, err)
+	}
+	if discard {
+		return os.Remove(tempName)
+	}
+	if _, err := cfg.BackupFile(cfg.OutputFilename); err != nil {
+		os.Remove(tempName)
+		return err
 	}
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(cfg.OutputFilename); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tempName, perm); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	if err := os.Rename(tempName, cfg.OutputFilename); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("failed to write to output file %s: %v", cfg.OutputFilename, err)
+	}
+	return nil
 }
 
 // BuildPrompt constructs the final prompt from the template and data
@@ -0,0 +1,56 @@
+package acode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xyproto/ask"
+)
+
+// ErrNotConfirmed is returned when an action that would change existing files was not confirmed
+var ErrNotConfirmed = errors.New("not confirmed")
+
+// Confirmer decides if an action that changes existing files should be carried out.
+// The details may contain a diff or other information that is useful for making the decision.
+type Confirmer interface {
+	Confirm(question, details string) bool
+}
+
+// ConfirmerFunc makes it possible to use a function as a Confirmer
+type ConfirmerFunc func(question, details string) bool
+
+// Confirm calls the function
+func (f ConfirmerFunc) Confirm(question, details string) bool {
+	return f(question, details)
+}
+
+// InteractiveConfirmer prints the details and asks a yes/no question on the terminal, where no is the default
+type InteractiveConfirmer struct{}
+
+// Confirm prints the details and asks the question on the terminal
+func (InteractiveConfirmer) Confirm(question, details string) bool {
+	if details != "" {
+		fmt.Print(details)
+	}
+	return ask.YN(question)
+}
+
+// confirm returns true if cfg.Force is set, or if cfg.Confirmer confirms the question.
+// If no Confirmer is configured, nothing is confirmed, so that the library never reads from the terminal on its own.
+func (cfg *Config) confirm(question, details string) bool {
+	if cfg.Force {
+		return true
+	}
+	if cfg.Confirmer == nil {
+		return false
+	}
+	return cfg.Confirmer.Confirm(question, details)
+}
+
+// confirmDiff is like confirm, but the diff is also printed if cfg.Force is set, since it is then not shown by a Confirmer
+func (cfg *Config) confirmDiff(question, diff string) bool {
+	if cfg.Force && !cfg.Silent {
+		fmt.Print(diff)
+	}
+	return cfg.confirm(question, diff)
+}
@@ -0,0 +1,205 @@
+package acode
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// CoverBlock is a block of statements from a Go coverprofile
+type CoverBlock struct {
+	File      string // as given in the coverprofile, typically the import path followed by the file name
+	StartLine int
+	EndLine   int
+	NumStmt   int
+	Count     int
+}
+
+// UncoveredFunc is a Go function that is not fully covered by tests
+type UncoveredFunc struct {
+	Path      string // relative to Config.Directory
+	Package   string
+	Name      string // the function name, or Type.Method for methods
+	Source    string
+	StartLine int
+	EndLine   int
+	Coverage  float64 // from 0 to 1, or -1 if unknown
+}
+
+// ParseCoverProfile reads a coverprofile, as written by "go test -coverprofile"
+func ParseCoverProfile(filename string) ([]CoverBlock, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open coverprofile: %v", err)
+	}
+	defer f.Close()
+	var blocks []CoverBlock
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// The format is name.go:line.column,line.column numberOfStatements count
+		colon := strings.LastIndex(line, ":")
+		fields := strings.Fields(line[colon+1:])
+		if colon < 0 || len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: invalid coverprofile line: %s", filename, lineNumber, line)
+		}
+		span := strings.Split(fields[0], ",")
+		if len(span) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid coverprofile block: %s", filename, lineNumber, fields[0])
+		}
+		block := CoverBlock{File: line[:colon]}
+		var errs [4]error
+		block.StartLine, errs[0] = strconv.Atoi(strings.SplitN(span[0], ".", 2)[0])
+		block.EndLine, errs[1] = strconv.Atoi(strings.SplitN(span[1], ".", 2)[0])
+		block.NumStmt, errs[2] = strconv.Atoi(fields[1])
+		block.Count, errs[3] = strconv.Atoi(fields[2])
+		for _, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid number in coverprofile: %v", filename, lineNumber, err)
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, scanner.Err()
+}
+
+// goModulePath returns the module path from the go.mod file in the given directory, or a blank string
+func goModulePath(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// funcName returns the name of a function declaration, as Type.Method for methods
+func funcName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return fd.Name.Name
+	}
+	t := fd.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if index, ok := t.(*ast.IndexExpr); ok { // generic receiver
+		t = index.X
+	}
+	if ident, ok := t.(*ast.Ident); ok {
+		return ident.Name + "." + fd.Name.Name
+	}
+	return fd.Name.Name
+}
+
+// isGoSource checks if the file is a Go source file that is not a test
+func isGoSource(path string) bool {
+	return strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go")
+}
+
+// testFilesIn returns the contents of all _test.go files in the project, by directory
+func testFilesIn(project *projectinfo.ProjectInfo) map[string][]string {
+	tests := make(map[string][]string)
+	for _, file := range project.SourceFiles {
+		if strings.HasSuffix(file.Path, "_test.go") {
+			dir := filepath.Dir(file.Path)
+			tests[dir] = append(tests[dir], file.Contents)
+		}
+	}
+	return tests
+}
+
+// FindUncoveredFunctions finds the Go functions in the project that are not fully covered by tests.
+// If blocks from a coverprofile are given, they are used. If not, functions that are not mentioned
+// in any _test.go file in the same directory are regarded as uncovered.
+// The functions are sorted by coverage, with the least covered first.
+func (cfg *Config) FindUncoveredFunctions(project *projectinfo.ProjectInfo, blocks []CoverBlock) ([]UncoveredFunc, error) {
+	var (
+		uncovered  []UncoveredFunc
+		tests      = testFilesIn(project)
+		modulePath = goModulePath(cfg.Directory)
+	)
+	for _, file := range project.SourceFiles {
+		if !isGoSource(file.Path) {
+			continue
+		}
+		rel := cfg.relativeProjectPath(file.Path)
+		var fileBlocks []CoverBlock
+		for _, block := range blocks {
+			if modulePath != "" && strings.HasPrefix(block.File, modulePath+"/") {
+				if strings.TrimPrefix(block.File, modulePath+"/") == filepath.ToSlash(rel) {
+					fileBlocks = append(fileBlocks, block)
+				}
+			} else if sameFile(block.File, rel) {
+				fileBlocks = append(fileBlocks, block)
+			}
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, file.Path, file.Contents, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", file.Path, err)
+		}
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Body == nil || fd.Name.Name == "init" || fd.Name.Name == "main" {
+				continue
+			}
+			start, end := fset.Position(fd.Pos()), fset.Position(fd.End())
+			fn := UncoveredFunc{
+				Path:      rel,
+				Package:   f.Name.Name,
+				Name:      funcName(fd),
+				Source:    file.Contents[start.Offset:end.Offset],
+				StartLine: start.Line,
+				EndLine:   end.Line,
+				Coverage:  -1,
+			}
+			if len(blocks) > 0 {
+				total, covered := 0, 0
+				for _, block := range fileBlocks {
+					if block.StartLine >= fn.StartLine && block.EndLine <= fn.EndLine {
+						total += block.NumStmt
+						if block.Count > 0 {
+							covered += block.NumStmt
+						}
+					}
+				}
+				if total == 0 || covered == total {
+					continue
+				}
+				fn.Coverage = float64(covered) / float64(total)
+			} else {
+				mentioned := false
+				for _, contents := range tests[filepath.Dir(file.Path)] {
+					if strings.Contains(contents, fd.Name.Name) {
+						mentioned = true
+						break
+					}
+				}
+				if mentioned {
+					continue
+				}
+			}
+			uncovered = append(uncovered, fn)
+		}
+	}
+	sort.SliceStable(uncovered, func(i, j int) bool {
+		return uncovered[i].Coverage < uncovered[j].Coverage
+	})
+	return uncovered, nil
+}
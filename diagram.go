@@ -0,0 +1,368 @@
+package acode
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+const (
+	// DiagramStartMarker and DiagramEndMarker surround the generated diagram in DOC.md, so that it can be replaced later
+	DiagramStartMarker = "<!-- acode:diagram -->"
+	DiagramEndMarker   = "<!-- acode:end-diagram -->"
+)
+
+// GraphPackage is a package in the import graph
+type GraphPackage struct {
+	ID       string `json:"id"` // the import path
+	Name     string `json:"name"`
+	Dir      string `json:"dir,omitempty"` // relative to Config.Directory, blank for external packages
+	Files    int    `json:"files,omitempty"`
+	External bool   `json:"external,omitempty"`
+}
+
+// GraphEdge is an import of one package by another
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ImportGraph is the package and import graph of a Go project
+type ImportGraph struct {
+	Packages []GraphPackage `json:"packages"`
+	Edges    []GraphEdge    `json:"edges"`
+}
+
+// DiagramGroup is a named group of packages, as suggested by the AI
+type DiagramGroup struct {
+	Name     string   `json:"name"`
+	Packages []string `json:"packages"`
+}
+
+// DiagramAnnotations are the groups and descriptions that the AI adds to the import graph
+type DiagramAnnotations struct {
+	Summary      string            `json:"summary"`
+	Groups       []DiagramGroup    `json:"groups"`
+	Descriptions map[string]string `json:"descriptions"`
+}
+
+var diagramLabelRegexp = regexp.MustCompile(`["<>{}\[\]|\\]`)
+
+// isStandardLibrary checks if the import path belongs to the Go standard library,
+// where the first path element does not contain a dot
+func isStandardLibrary(importPath string) bool {
+	return !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".")
+}
+
+// BuildImportGraph parses the imports of the Go files in the project, and returns the graph of the packages in the
+// project and the external packages that they import. Test files and the standard library are left out.
+func (cfg *Config) BuildImportGraph(project *projectinfo.ProjectInfo) (*ImportGraph, error) {
+	modulePath := goModulePath(cfg.Directory)
+	packages := make(map[string]*GraphPackage)
+	edges := make(map[GraphEdge]bool)
+	fset := token.NewFileSet()
+	for _, file := range project.SourceFiles {
+		if !isGoSource(file.Path) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file.Path, file.Contents, parser.ImportsOnly)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", file.Path, err)
+		}
+		dir := filepath.ToSlash(filepath.Dir(cfg.relativeProjectPath(file.Path)))
+		id := dir
+		if modulePath != "" {
+			id = strings.TrimSuffix(modulePath+"/"+dir, "/.")
+		}
+		pkg, ok := packages[id]
+		if !ok {
+			pkg = &GraphPackage{ID: id, Name: f.Name.Name, Dir: dir}
+			packages[id] = pkg
+		}
+		pkg.Files++
+		for _, imp := range f.Imports {
+			importPath, err := strconv.Unquote(imp.Path.Value)
+			if err != nil || isStandardLibrary(importPath) {
+				continue
+			}
+			if modulePath == "" || (importPath != modulePath && !strings.HasPrefix(importPath, modulePath+"/")) {
+				if _, ok := packages[importPath]; !ok {
+					packages[importPath] = &GraphPackage{ID: importPath, Name: filepath.Base(importPath), External: true}
+				}
+			}
+			if importPath != id {
+				edges[GraphEdge{From: id, To: importPath}] = true
+			}
+		}
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no Go packages found, import graphs can only be built for Go projects")
+	}
+	graph := &ImportGraph{}
+	for _, pkg := range packages {
+		graph.Packages = append(graph.Packages, *pkg)
+	}
+	sort.Slice(graph.Packages, func(i, j int) bool {
+		if graph.Packages[i].External != graph.Packages[j].External {
+			return !graph.Packages[i].External
+		}
+		return graph.Packages[i].ID < graph.Packages[j].ID
+	})
+	for edge := range edges {
+		// Imports of packages in the module that have no Go files in the project are left out
+		if _, ok := packages[edge.To]; ok {
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph, nil
+}
+
+// ParseDiagramAnnotations parses the JSON annotations from an AI response.
+// Packages that are not in the graph are removed, so that the AI can not add packages or imports.
+func ParseDiagramAnnotations(response string, graph *ImportGraph) (*DiagramAnnotations, error) {
+	var annotations DiagramAnnotations
+	text := strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response)))
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	if err := json.Unmarshal([]byte(text), &annotations); err != nil {
+		return nil, fmt.Errorf("could not parse the diagram annotations: %v", err)
+	}
+	known := make(map[string]bool)
+	for _, pkg := range graph.Packages {
+		known[pkg.ID] = true
+	}
+	grouped := make(map[string]bool)
+	var groups []DiagramGroup
+	for _, group := range annotations.Groups {
+		var ids []string
+		for _, id := range group.Packages {
+			if known[id] && !grouped[id] {
+				grouped[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 && strings.TrimSpace(group.Name) != "" {
+			groups = append(groups, DiagramGroup{Name: strings.TrimSpace(group.Name), Packages: ids})
+		}
+	}
+	annotations.Groups = groups
+	for id := range annotations.Descriptions {
+		if !known[id] {
+			delete(annotations.Descriptions, id)
+		}
+	}
+	return &annotations, nil
+}
+
+// diagramLabel returns the label for a package in a diagram, with the description on a second line, if there is one
+func diagramLabel(pkg GraphPackage, annotations *DiagramAnnotations, lineBreak string) string {
+	label := pkg.ID
+	if pkg.Dir != "" {
+		label = pkg.Dir
+		if pkg.Dir == "." {
+			label = pkg.Name
+		}
+	}
+	label = diagramLabelRegexp.ReplaceAllString(label, "")
+	if annotations != nil {
+		if description := diagramLabelRegexp.ReplaceAllString(annotations.Descriptions[pkg.ID], ""); description != "" {
+			label += lineBreak + description
+		}
+	}
+	return label
+}
+
+// diagramNodeIDs returns short node IDs for the packages, since import paths can not be used as IDs in Mermaid or DOT
+func diagramNodeIDs(graph *ImportGraph) map[string]string {
+	ids := make(map[string]string)
+	for i, pkg := range graph.Packages {
+		ids[pkg.ID] = "p" + strconv.Itoa(i)
+	}
+	return ids
+}
+
+// ungroupedPackages returns the packages that are not in any group
+func ungroupedPackages(graph *ImportGraph, annotations *DiagramAnnotations) []GraphPackage {
+	grouped := make(map[string]bool)
+	if annotations != nil {
+		for _, group := range annotations.Groups {
+			for _, id := range group.Packages {
+				grouped[id] = true
+			}
+		}
+	}
+	var ungrouped []GraphPackage
+	for _, pkg := range graph.Packages {
+		if !grouped[pkg.ID] {
+			ungrouped = append(ungrouped, pkg)
+		}
+	}
+	return ungrouped
+}
+
+// packageByID returns the package with the given import path
+func (graph *ImportGraph) packageByID(id string) GraphPackage {
+	for _, pkg := range graph.Packages {
+		if pkg.ID == id {
+			return pkg
+		}
+	}
+	return GraphPackage{ID: id}
+}
+
+// RenderMermaid renders the import graph as a Mermaid flowchart, with the groups as subgraphs
+func RenderMermaid(graph *ImportGraph, annotations *DiagramAnnotations) string {
+	var (
+		sb  strings.Builder
+		ids = diagramNodeIDs(graph)
+	)
+	sb.WriteString("flowchart LR\n")
+	node := func(indent string, pkg GraphPackage) {
+		label := diagramLabel(pkg, annotations, "<br/>")
+		if pkg.External {
+			fmt.Fprintf(&sb, "%s%s([\"%s\"])\n", indent, ids[pkg.ID], label)
+		} else {
+			fmt.Fprintf(&sb, "%s%s[\"%s\"]\n", indent, ids[pkg.ID], label)
+		}
+	}
+	if annotations != nil {
+		for i, group := range annotations.Groups {
+			fmt.Fprintf(&sb, "    subgraph g%d[\"%s\"]\n", i, diagramLabelRegexp.ReplaceAllString(group.Name, ""))
+			for _, id := range group.Packages {
+				node("        ", graph.packageByID(id))
+			}
+			sb.WriteString("    end\n")
+		}
+	}
+	for _, pkg := range ungroupedPackages(graph, annotations) {
+		node("    ", pkg)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&sb, "    %s --> %s\n", ids[edge.From], ids[edge.To])
+	}
+	return sb.String()
+}
+
+// RenderDOT renders the import graph as a Graphviz DOT digraph, with the groups as clusters
+func RenderDOT(graph *ImportGraph, annotations *DiagramAnnotations) string {
+	var (
+		sb  strings.Builder
+		ids = diagramNodeIDs(graph)
+	)
+	sb.WriteString("digraph packages {\n    rankdir=LR;\n    node [shape=box];\n")
+	node := func(indent string, pkg GraphPackage) {
+		attributes := "label=" + strconv.Quote(diagramLabel(pkg, annotations, "\n"))
+		if pkg.External {
+			attributes += ", style=dashed"
+		}
+		fmt.Fprintf(&sb, "%s%s [%s];\n", indent, ids[pkg.ID], attributes)
+	}
+	if annotations != nil {
+		for i, group := range annotations.Groups {
+			fmt.Fprintf(&sb, "    subgraph cluster_%d {\n        label=%s;\n", i, strconv.Quote(group.Name))
+			for _, id := range group.Packages {
+				node("        ", graph.packageByID(id))
+			}
+			sb.WriteString("    }\n")
+		}
+	}
+	for _, pkg := range ungroupedPackages(graph, annotations) {
+		node("    ", pkg)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&sb, "    %s -> %s;\n", ids[edge.From], ids[edge.To])
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// diagramFormat returns the selected diagram format, "mermaid" by default
+func (cfg *Config) diagramFormat() string {
+	if cfg.DiagramFormat == "" {
+		return "mermaid"
+	}
+	return strings.ToLower(cfg.DiagramFormat)
+}
+
+// GenerateDiagram builds the import graph of the project, asks the AI to group and describe the packages,
+// and returns a Markdown section with the diagram, the approximate cost in USD and an error, if any.
+// If the annotations from the AI can not be used, the diagram is rendered without them.
+func (cfg *Config) GenerateDiagram(status io.Writer, project *projectinfo.ProjectInfo) (string, float64, error) {
+	format := cfg.diagramFormat()
+	if format != "mermaid" && format != "dot" {
+		return "", 0, fmt.Errorf("unknown diagram format %q, expected \"mermaid\" or \"dot\"", cfg.DiagramFormat)
+	}
+	graph, err := cfg.BuildImportGraph(project)
+	if err != nil {
+		return "", 0, err
+	}
+	fmt.Fprintf(status, "Found %d package(s) and %d import(s).\n", len(graph.Packages), len(graph.Edges))
+	if !cfg.Silent {
+		log.Printf("Found %d package(s) and %d import(s).\n", len(graph.Packages), len(graph.Edges))
+	}
+	graphJSON, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return "", 0, err
+	}
+	prompt, err := cfg.BuildPrompt(cfg.InitialPrompt, TemplateData{
+		ReadmeContents: "\n\n" + FileContents(project, "README.md") + "\n",
+		Graph:          "\n\n" + string(graphJSON) + "\n",
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	response, usdCost, err := cfg.postAndReport(status, "[diagram] ", prompt, cfg.CountPromptTokens(prompt))
+	var annotations *DiagramAnnotations
+	if err != nil {
+		fmt.Fprintf(status, "Warning: could not get annotations for the diagram: %v\n", err)
+	} else if annotations, err = ParseDiagramAnnotations(response, graph); err != nil {
+		fmt.Fprintf(status, "Warning: %v\n", err)
+		annotations = nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(DiagramStartMarker + "\n## Architecture diagram\n\n")
+	if annotations != nil && strings.TrimSpace(annotations.Summary) != "" {
+		sb.WriteString(strings.TrimSpace(annotations.Summary) + "\n\n")
+	}
+	if format == "dot" {
+		sb.WriteString("```dot\n" + RenderDOT(graph, annotations) + "```\n")
+	} else {
+		sb.WriteString("```mermaid\n" + RenderMermaid(graph, annotations) + "```\n")
+	}
+	sb.WriteString(DiagramEndMarker + "\n")
+	return sb.String(), usdCost, nil
+}
+
+// EmbedDiagram places the diagram section in the document, replacing the previously generated diagram if there is one,
+// or adding it to the end of the document if not
+func EmbedDiagram(document, section string) string {
+	start := strings.Index(document, DiagramStartMarker)
+	if start >= 0 {
+		if end := strings.Index(document[start:], DiagramEndMarker); end >= 0 {
+			rest := strings.TrimPrefix(document[start+end+len(DiagramEndMarker):], "\n")
+			return document[:start] + section + rest
+		}
+	}
+	if strings.TrimSpace(document) == "" {
+		return section
+	}
+	return strings.TrimRight(document, "\n") + "\n\n" + section
+}
@@ -0,0 +1,223 @@
+package acode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffContext is the number of unchanged lines that are shown around each change in a unified diff
+var DiffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// splitLines splits a string into lines, without a trailing empty line if the string ends with a newline
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// noNewlineMarker is added to the last line of a file that does not end with a newline, so that it differs from the
+// same line with a newline, and is shown with a "\ No newline at end of file" line
+const noNewlineMarker = "\x00no newline"
+
+// diffFileLines splits the contents into lines for diffing, where the last line is marked if it has no newline
+func diffFileLines(contents string) []string {
+	lines := splitLines(contents)
+	if len(lines) > 0 && !strings.HasSuffix(contents, "\n") {
+		lines[len(lines)-1] += noNewlineMarker
+	}
+	return lines
+}
+
+// diffLines finds the shortest edit script between a and b, using the linear space variant of the Myers algorithm,
+// so that large files can be compared without keeping a trace for every edit distance
+func diffLines(a, b []string) []diffOp {
+	return appendDiff(nil, a, b)
+}
+
+// appendDiff appends the operations that turn a into b. The common prefix and suffix are found first, and the rest
+// is split in two at a point on a shortest edit path, which is found by diffBisect.
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, diffOp{' ', a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if x, y, ok := diffBisect(a, b); ok {
+		ops = appendDiff(ops, a[:x], b[:y])
+		ops = appendDiff(ops, a[x:], b[y:])
+	} else {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	}
+	for _, line := range common {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// diffBisect searches for the middle of a shortest edit path between a and b, from both ends at once.
+// Returns the point where the path can be split, or false if a or b is empty, or if both are a single line,
+// since all lines of a are then deleted and all lines of b are inserted.
+func diffBisect(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 || (n == 1 && m == 1) {
+		return 0, 0, false
+	}
+	var (
+		maxD    = (n + m + 1) / 2
+		offset  = maxD + 1
+		vLength = 2*maxD + 3
+		v1      = make([]int, vLength) // the furthest x on each diagonal, from the start
+		v2      = make([]int, vLength) // the furthest x on each diagonal, from the end
+		delta   = n - m
+		front   = delta%2 != 0 // if the paths from the start are the ones that can meet the paths from the end
+	)
+	var k1start, k1end, k2start, k2end int // the diagonals that have gone past the edges
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+	for d := 0; d < maxD; d++ {
+		// Walk the paths from the start
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			var x1 int
+			if k1 == -d || (k1 != d && v1[offset+k1-1] < v1[offset+k1+1]) {
+				x1 = v1[offset+k1+1]
+			} else {
+				x1 = v1[offset+k1-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[offset+k1] = x1
+			switch {
+			case x1 > n:
+				k1end += 2 // past the right edge
+			case y1 > m:
+				k1start += 2 // past the bottom edge
+			case front:
+				if k2 := offset + delta - k1; k2 >= 0 && k2 < vLength && v2[k2] != -1 && x1 >= n-v2[k2] {
+					return x1, y1, true
+				}
+			}
+		}
+		// Walk the paths from the end
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			var x2 int
+			if k2 == -d || (k2 != d && v2[offset+k2-1] < v2[offset+k2+1]) {
+				x2 = v2[offset+k2+1]
+			} else {
+				x2 = v2[offset+k2-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[offset+k2] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				if k1 := offset + delta - k2; k1 >= 0 && k1 < vLength && v1[k1] != -1 {
+					x1 := v1[k1]
+					if x1 >= n-x2 {
+						return x1, x1 - (k1 - offset), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// UnifiedDiff returns a unified diff between the old and the new contents, or a blank string if they are equal
+func UnifiedDiff(oldName, newName, oldContents, newContents string) string {
+	if oldContents == newContents {
+		return ""
+	}
+	ops := diffLines(diffFileLines(oldContents), diffFileLines(newContents))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start >= len(ops) {
+			break
+		}
+		// Extend the hunk until there are more than 2*DiffContext unchanged lines in a row
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*DiffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && ops[end-1].kind == ' ' {
+			end--
+		}
+		from := max(0, start-DiffContext)
+		to := min(len(ops), end+DiffContext)
+
+		// Count the line numbers up to the start of the hunk
+		oldStart, newStart := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				oldStart++
+			}
+			if op.kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		var body strings.Builder
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+			if text, found := strings.CutSuffix(op.text, noNewlineMarker); found {
+				body.WriteString(string(op.kind) + text + "\n\\ No newline at end of file\n")
+			} else {
+				body.WriteString(string(op.kind) + op.text + "\n")
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		sb.WriteString(body.String())
+		start = to
+	}
+	return sb.String()
+}
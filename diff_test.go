@@ -0,0 +1,80 @@
+package acode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "new file",
+			old:  "",
+			new:  "a\nb\n",
+			want: "--- f\n+++ f\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "only the final newline is added",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "only the final newline is removed",
+			old:  "a\n",
+			new:  "a",
+			want: "--- f\n+++ f\n@@ -1,1 +1,1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "two hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("f", "f", tt.old, tt.new); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffRoundTrip(t *testing.T) {
+	tests := []struct {
+		old, new string
+	}{
+		{"a\nb\nc\nd\ne\nf\ng\nh\n", "a\nc\nd\nx\ne\nf\ng\nh\ni\n"},
+		{"package main\n\nfunc main() {\n}\n", "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println()\n}\n"},
+		{strings.Repeat("same\n", 20) + "old\n", "new\n" + strings.Repeat("same\n", 20)},
+		{"a\nb\nc\nd\n", "d\nc\nb\na\n"},
+		{strings.Repeat("x\ny\n", 50), strings.Repeat("y\nx\nz\n", 40)},
+	}
+	for _, tt := range tests {
+		patches := ExtractPatches(UnifiedDiff("a/f", "b/f", tt.old, tt.new))
+		if len(patches) != 1 {
+			t.Fatalf("expected one patch, got %d", len(patches))
+		}
+		got, _, rejected := ApplyHunks("f", tt.old, patches[0].Hunks)
+		if len(rejected) > 0 || got != tt.new {
+			t.Errorf("applying the diff gave %q and %v, want %q", got, rejected, tt.new)
+		}
+	}
+}
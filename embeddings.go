@@ -0,0 +1,137 @@
+package acode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xyproto/env/v2"
+)
+
+// Embedder turns texts into embedding vectors
+type Embedder interface {
+	// Embed returns one vector per text, in the same order
+	Embed(texts []string) ([][]float32, error)
+	// Name identifies the embedding model, so that stored vectors from other models are not mixed in
+	Name() string
+}
+
+// OpenAIEmbedder uses an OpenAI compatible /v1/embeddings endpoint, which can also be a local server
+type OpenAIEmbedder struct {
+	URL       string // the full URL of the embeddings endpoint
+	Model     string
+	APIKey    string // sent as a bearer token, if not blank
+	BatchSize int    // the maximum number of texts per request
+	Timeout   time.Duration
+}
+
+// NewOpenAIEmbedder returns an embedder for the given base URL, like "http://localhost:8080" or "https://api.openai.com",
+// and model name. "/v1/embeddings" is added to the URL if it does not already end with "/embeddings".
+// The API key is read from the OPENAI_API_KEY environment variable.
+func NewOpenAIEmbedder(baseURL, model string) *OpenAIEmbedder {
+	url := strings.TrimSuffix(baseURL, "/")
+	if !strings.HasSuffix(url, "/embeddings") {
+		url += "/v1/embeddings"
+	}
+	return &OpenAIEmbedder{
+		URL:       url,
+		Model:     model,
+		APIKey:    env.Str("OPENAI_API_KEY"),
+		BatchSize: 64,
+		Timeout:   2 * time.Minute,
+	}
+}
+
+// Name returns the model name and the URL
+func (e *OpenAIEmbedder) Name() string {
+	return e.Model + "@" + e.URL
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Embed sends the texts to the embeddings endpoint, in batches of e.BatchSize
+func (e *OpenAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	batchSize := e.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(texts)
+	}
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := e.embedBatch(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// embedBatch sends one request to the embeddings endpoint
+func (e *OpenAIEmbedder) embedBatch(texts []string) ([][]float32, error) {
+	requestBody, err := json.Marshal(embeddingRequest{Model: e.Model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", e.URL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+	resp, err := (&http.Client{Timeout: e.Timeout}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	var response embeddingResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("could not parse the embeddings response (status %s): %v", resp.Status, err)
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("embeddings error from %s: %s", e.URL, response.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings request to %s failed: %s", e.URL, resp.Status)
+	}
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings from %s, got %d", len(texts), e.URL, len(response.Data))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range response.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("invalid embedding index %d from %s", d.Index, e.URL)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
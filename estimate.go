@@ -0,0 +1,174 @@
+package acode
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// ChunkEstimate is the estimated token count for one prompt, in one of the phases of Process
+type ChunkEstimate struct {
+	Phase          string
+	Chunk          int // from 0
+	SentTokens     int
+	ReceivedTokens int // estimated with expectedOutputTokens
+}
+
+// CostEstimate is the estimated token count for all prompts that Process would send
+type CostEstimate struct {
+	Chunks    []ChunkEstimate
+	Phases    []string // the phases that are included, in order
+	MaxTokens int      // the context window the project was chunked for
+	Model     string   // the model the project was chunked for
+}
+
+// expectedOutputTokens estimates how many tokens the AI will answer with, for a prompt with the given number of
+// source code tokens, in the given phase of the given operation
+func expectedOutputTokens(opType OperationType, phase string, sourceTokens int) int {
+	ratio, minimum := 0.0, 0
+	switch phase {
+	case PhaseConfidence:
+		return 5 // a single number
+	case PhaseFix:
+		ratio, minimum = 0.15, 200 // patches for the findings
+	default:
+		switch opType {
+		case OpFindBug, OpFindTypo, OpReview, OpSecurityAudit:
+			ratio, minimum = 0.05, 100 // a list of findings
+		case OpGenChangelog:
+			return 500
+		case OpGenCommitMsg:
+			return 150
+		case OpGenOpenAPI, OpGenCatalog:
+			ratio, minimum = 0.3, 400 // a structured document that grows with the API
+		default:
+			ratio, minimum = 0.25, 300 // documentation or a generated file
+		}
+	}
+	expected := int(float64(sourceTokens) * ratio)
+	if expected < minimum {
+		return minimum
+	}
+	return expected
+}
+
+// estimateCost builds the prompts that Process would send for the given chunks, and counts the tokens, without
+// sending anything. The tokens are counted locally, since counting them on the server is also a request.
+func (cfg *Config) estimateCost(project *projectinfo.ProjectInfo, jsonChunks []string) (*CostEstimate, error) {
+	estimate := &CostEstimate{
+		Phases:    []string{PhaseInitial},
+		MaxTokens: cfg.Model.MaxTokens,
+		Model:     cfg.Model.Name,
+	}
+	if cfg.AlsoOutputFixAndConfidence {
+		estimate.Phases = append(estimate.Phases, PhaseFix, PhaseConfidence)
+	}
+	var (
+		combinedInitialTokens int // the fix and confidence prompts include the combined initial answers
+		cumulativeTokens      int // OpGenOpenAPI includes the specification from the previous chunks
+	)
+	for _, phase := range estimate.Phases {
+		promptTemplate := cfg.InitialPrompt
+		switch phase {
+		case PhaseFix:
+			promptTemplate = cfg.FixPrompt
+		case PhaseConfidence:
+			promptTemplate = cfg.ConfidencePrompt
+		}
+		for i, jsonChunk := range jsonChunks {
+			chunk := cfg.trimChunkPaths(jsonChunk)
+			prompt, err := cfg.BuildPrompt(promptTemplate, cfg.templateData(project, chunk, ""))
+			if err != nil {
+				return nil, err
+			}
+			sourceTokens := projectinfo.CountTokens(chunk)
+			sent := projectinfo.CountTokens(prompt)
+			received := expectedOutputTokens(cfg.OpType, phase, sourceTokens)
+			switch {
+			case phase != PhaseInitial:
+				sent += combinedInitialTokens
+			case cfg.OpType == OpGenOpenAPI:
+				sent += cumulativeTokens
+				received += cumulativeTokens // the whole specification is returned each time
+				cumulativeTokens = received
+			}
+			estimate.Chunks = append(estimate.Chunks, ChunkEstimate{phase, i, sent, received})
+		}
+		if phase == PhaseInitial {
+			for _, c := range estimate.Chunks {
+				combinedInitialTokens += c.ReceivedTokens
+			}
+			if cfg.OpType == OpGenOpenAPI {
+				combinedInitialTokens = cumulativeTokens
+			}
+		}
+	}
+	return estimate, nil
+}
+
+// Cost returns the estimated cost in USD for each phase, and in total, when using the given model.
+// Also returns true if at least one of the prompts does not fit in the context window of the model.
+func (estimate *CostEstimate) Cost(model Model) (map[string]float64, float64, bool) {
+	var (
+		byPhase  = make(map[string]float64)
+		total    float64
+		tooLarge bool
+	)
+	for _, c := range estimate.Chunks {
+		usdCost := model.CalculateCost(c.SentTokens, c.ReceivedTokens)
+		byPhase[c.Phase] += usdCost
+		total += usdCost
+		if model.MaxTokens > 0 && c.SentTokens > model.MaxTokens {
+			tooLarge = true
+		}
+	}
+	return byPhase, total, tooLarge
+}
+
+// Format returns a per chunk and per phase breakdown of the estimate, and the estimated cost for each of the given models
+func (estimate *CostEstimate) Format(models []Model) string {
+	var sb strings.Builder
+	n := len(estimate.Chunks) / len(estimate.Phases)
+	fmt.Fprintf(&sb, "Estimated cost for %d chunk(s), chunked for the %d token context window of %s. No prompts were sent.\n\n", n, estimate.MaxTokens, estimate.Model)
+
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Phase\tChunk\tSent tokens\tExpected received tokens\t")
+	var totalSent, totalReceived int
+	for _, phase := range estimate.Phases {
+		var phaseSent, phaseReceived int
+		for _, c := range estimate.Chunks {
+			if c.Phase != phase {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d/%d\t%d\t%d\t\n", c.Phase, c.Chunk+1, n, c.SentTokens, c.ReceivedTokens)
+			phaseSent += c.SentTokens
+			phaseReceived += c.ReceivedTokens
+		}
+		fmt.Fprintf(w, "%s\tall\t%d\t%d\t\n", phase, phaseSent, phaseReceived)
+		totalSent += phaseSent
+		totalReceived += phaseReceived
+	}
+	fmt.Fprintf(w, "total\t\t%d\t%d\t\n", totalSent, totalReceived)
+	w.Flush()
+
+	sb.WriteString("\n")
+	w = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Model\t%s\tTotal\t\n", strings.Join(estimate.Phases, "\t"))
+	for _, model := range models {
+		byPhase, total, tooLarge := estimate.Cost(model)
+		fmt.Fprintf(w, "%s\t", model.Name)
+		for _, phase := range estimate.Phases {
+			fmt.Fprintf(w, "$%.2f\t", byPhase[phase])
+		}
+		note := ""
+		if tooLarge {
+			note = " (some prompts exceed the context window)"
+		}
+		fmt.Fprintf(w, "$%.2f%s\t\n", total, note)
+	}
+	w.Flush()
+	sb.WriteString("\nThe received tokens are estimated, and correction prompts for invalid output are not included.")
+	return sb.String()
+}
@@ -0,0 +1,116 @@
+package acode
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Finding is a single bug, typo, review comment or security issue, as reported by the AI
+type Finding struct {
+	Category         string // "bug", "typo", "review" or "security"
+	Path             string
+	Line             int // 0 if the line is unknown
+	EndLine          int
+	Message          string
+	Severity         string // "error", "warning" or "note"
+	CWE              string // like "CWE-89", for security findings
+	SecuritySeverity string // "critical", "high", "medium" or "low", for security findings
+	Fixes            []Hunk // suggested fixes, from the fix phase
+}
+
+// findingRegexp matches lines like "path/to/file.go:12: description", with optional list markers, backticks and a line range
+var findingRegexp = regexp.MustCompile("^\\s*(?:[-*]\\s+|\\d+[.)]\\s+)?(?:\\*\\*)?`?([\\w./\\\\-]+\\.\\w+)`?(?::(\\d+)(?:-(\\d+))?)?(?::\\d+)?`?(?:\\*\\*)?\\s*[:\\-–]\\s+(.+)$")
+
+// RuleID returns the rule ID for the category of the finding, and for the CWE, if there is one
+func (f *Finding) RuleID() string {
+	if f.CWE != "" {
+		return "acode/" + f.Category + "/" + f.CWE
+	}
+	return "acode/" + f.Category
+}
+
+// categoryForOperation returns the findings category for the given operation type
+func categoryForOperation(opType OperationType) string {
+	switch opType {
+	case OpFindTypo:
+		return "typo"
+	case OpReview:
+		return "review"
+	case OpSecurityAudit:
+		return "security"
+	default:
+		return "bug"
+	}
+}
+
+// defaultSeverity returns the severity that is used for a category when the AI does not specify one
+func defaultSeverity(category string) string {
+	if category == "typo" {
+		return "note"
+	}
+	return "warning"
+}
+
+// ParseFindings extracts structured findings from an AI response to a bug finding, typo finding or review prompt.
+// Each finding is expected to be on a line of its own, in the form "path/to/file:LINE: description".
+// Indented lines that do not mention a file name are regarded as part of the previous finding.
+// Security findings may also be tagged with a CWE ID and a severity, like "[CWE-89] [high]".
+func ParseFindings(opType OperationType, response string) []Finding {
+	var (
+		findings []Finding
+		category = categoryForOperation(opType)
+	)
+	for _, line := range strings.Split(response, "\n") {
+		m := findingRegexp.FindStringSubmatch(line)
+		if m == nil {
+			if len(findings) > 0 && strings.TrimSpace(line) != "" && (line[0] == ' ' || line[0] == '\t') {
+				findings[len(findings)-1].Message += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		finding := Finding{
+			Category: category,
+			Path:     strings.TrimPrefix(filepath.ToSlash(m[1]), "./"),
+			Message:  strings.TrimSpace(m[4]),
+			Severity: defaultSeverity(category),
+		}
+		finding.Line, _ = strconv.Atoi(m[2])
+		finding.EndLine, _ = strconv.Atoi(m[3])
+		if finding.EndLine < finding.Line {
+			finding.EndLine = finding.Line
+		}
+		if category == "security" {
+			parseSecurityTags(&finding)
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// sameFile checks if two paths refer to the same file, when one of them may be a suffix of the other
+func sameFile(a, b string) bool {
+	a, b = filepath.ToSlash(filepath.Clean(a)), filepath.ToSlash(filepath.Clean(b))
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// AttachFixes adds the hunks from the fix responses to the findings that are in the same file,
+// and that overlap with the lines that the hunk changes (or to all findings in the file, if the line is unknown).
+func AttachFixes(findings []Finding, combinedFixResponses string) []Finding {
+	patches := ExtractPatches(combinedFixResponses)
+	for i := range findings {
+		f := &findings[i]
+		for _, fp := range patches {
+			if !sameFile(fp.Path(), f.Path) {
+				continue
+			}
+			for _, hunk := range fp.Hunks {
+				if f.Line == 0 || (f.Line <= hunk.OldStart+hunk.OldLines && f.EndLine >= hunk.OldStart) {
+					f.Fixes = append(f.Fixes, hunk)
+				}
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,229 @@
+package acode
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Formatter writes findings in a format that can be consumed by CI systems or code scanning dashboards.
+// analyzedFiles are the files that were analyzed, including the ones without findings.
+type Formatter interface {
+	Format(w io.Writer, findings []Finding, analyzedFiles []string) error
+}
+
+// SARIFFormatter writes findings as SARIF 2.1.0
+type SARIFFormatter struct{}
+
+// CheckstyleFormatter writes findings as Checkstyle XML
+type CheckstyleFormatter struct{}
+
+// JUnitFormatter writes findings as JUnit XML, with one test case per file that fails when the file has findings
+type JUnitFormatter struct{}
+
+// GitHubFormatter writes findings as GitHub Actions workflow commands, like "::warning file=main.go,line=3::message"
+type GitHubFormatter struct{}
+
+// Formatters maps output format names to formatters
+var Formatters = map[string]Formatter{
+	"sarif":      SARIFFormatter{},
+	"checkstyle": CheckstyleFormatter{},
+	"junit":      JUnitFormatter{},
+	"github":     GitHubFormatter{},
+}
+
+// FormatterFor returns the formatter for the given output format name, or for the extension of the given filename if the format is blank.
+// Returns nil if the output should be plain text.
+func FormatterFor(format, filename string) (Formatter, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		base := strings.ToLower(filepath.Base(filename))
+		switch {
+		case strings.HasSuffix(base, ".sarif"):
+			format = "sarif"
+		case strings.HasSuffix(base, ".xml") && strings.Contains(base, "checkstyle"):
+			format = "checkstyle"
+		case strings.HasSuffix(base, ".xml") && (strings.Contains(base, "junit") || strings.HasPrefix(base, "test-")):
+			format = "junit"
+		default:
+			format = "text"
+		}
+	}
+	if format == "text" {
+		return nil, nil
+	}
+	if formatter, ok := Formatters[format]; ok {
+		return formatter, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %s", format)
+}
+
+// Format writes the findings as SARIF 2.1.0
+func (SARIFFormatter) Format(w io.Writer, findings []Finding, _ []string) error {
+	data, err := SARIF(findings)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// findingsByFile groups the findings by path, and returns the paths in sorted order
+func findingsByFile(findings []Finding, analyzedFiles []string) ([]string, map[string][]Finding) {
+	byFile := make(map[string][]Finding)
+	for _, path := range analyzedFiles {
+		byFile[filepath.ToSlash(path)] = nil
+	}
+	for _, f := range findings {
+		path := f.Path
+		for _, analyzed := range analyzedFiles {
+			if sameFile(analyzed, f.Path) {
+				path = filepath.ToSlash(analyzed)
+				break
+			}
+		}
+		byFile[path] = append(byFile[path], f)
+	}
+	paths := make([]string, 0, len(byFile))
+	for path := range byFile {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, byFile
+}
+
+type checkstyleResult struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// Format writes the findings as Checkstyle XML. Only files with findings are included.
+func (CheckstyleFormatter) Format(w io.Writer, findings []Finding, _ []string) error {
+	result := checkstyleResult{Version: "8.0"}
+	paths, byFile := findingsByFile(findings, nil)
+	for _, path := range paths {
+		file := checkstyleFile{Name: path}
+		for _, f := range byFile[path] {
+			severity := f.Severity
+			if severity == "note" {
+				severity = "info"
+			}
+			file.Errors = append(file.Errors, checkstyleError{Line: f.Line, Severity: severity, Message: f.Message, Source: f.RuleID()})
+		}
+		result.Files = append(result.Files, file)
+	}
+	return writeXML(w, result)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Format writes the findings as JUnit XML, with one test case per analyzed file
+func (JUnitFormatter) Format(w io.Writer, findings []Finding, analyzedFiles []string) error {
+	suite := junitTestSuite{Name: "acode"}
+	paths, byFile := findingsByFile(findings, analyzedFiles)
+	for _, path := range paths {
+		testCase := junitTestCase{ClassName: "acode", Name: path}
+		if fileFindings := byFile[path]; len(fileFindings) > 0 {
+			var sb strings.Builder
+			for _, f := range fileFindings {
+				fmt.Fprintf(&sb, "%s:%d: [%s] %s\n", path, f.Line, f.RuleID(), f.Message)
+			}
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d finding(s)", len(fileFindings)),
+				Type:    fileFindings[0].RuleID(),
+				Text:    sb.String(),
+			}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+	}
+	return writeXML(w, junitTestSuites{Name: "acode", Tests: suite.Tests, Failures: suite.Failures, Suites: []junitTestSuite{suite}})
+}
+
+// escapeWorkflowData escapes a message for use in a GitHub Actions workflow command
+func escapeWorkflowData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeWorkflowProperty escapes a property value for use in a GitHub Actions workflow command
+func escapeWorkflowProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// Format writes the findings as GitHub Actions workflow commands
+func (GitHubFormatter) Format(w io.Writer, findings []Finding, _ []string) error {
+	for _, f := range findings {
+		command := "warning"
+		switch f.Severity {
+		case "error":
+			command = "error"
+		case "note":
+			command = "notice"
+		}
+		properties := "file=" + escapeWorkflowProperty(f.Path)
+		if f.Line > 0 {
+			properties += fmt.Sprintf(",line=%d,endLine=%d", f.Line, f.EndLine)
+		}
+		properties += ",title=" + escapeWorkflowProperty(f.RuleID())
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", command, properties, escapeWorkflowData(f.Message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeXML writes the given value as indented XML, with an XML header
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
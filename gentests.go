@@ -0,0 +1,161 @@
+package acode
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/projectinfo"
+)
+
+// MaxTestExampleLines is the maximum number of lines from each existing test file that are used as a style example
+var MaxTestExampleLines = 150
+
+// testExamples returns up to two existing test files from the given directory, or from anywhere in the project, as style examples
+func (cfg *Config) testExamples(project *projectinfo.ProjectInfo, dir string) string {
+	var examples []projectinfo.FileInfo
+	for _, sameDir := range []bool{true, false} {
+		for _, file := range project.SourceFiles {
+			if len(examples) >= 2 {
+				break
+			}
+			if strings.HasSuffix(file.Path, "_test.go") && (filepath.Dir(file.Path) == dir) == sameDir {
+				examples = append(examples, file)
+			}
+		}
+	}
+	if len(examples) == 0 {
+		return "\n\nThere are no existing tests in this project. Use the standard library testing package.\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("\n")
+	for _, example := range examples {
+		lines := strings.Split(example.Contents, "\n")
+		if len(lines) > MaxTestExampleLines {
+			lines = append(lines[:MaxTestExampleLines], "// ...")
+		}
+		fmt.Fprintf(&sb, "\n// %s\n%s\n", filepath.ToSlash(cfg.relativeProjectPath(example.Path)), strings.Join(lines, "\n"))
+	}
+	return sb.String()
+}
+
+// generatedTestFilename returns the name of the test file to generate for the given source file,
+// which is name_test.go, or name_generated_test.go if name_test.go already exists
+func generatedTestFilename(fullPath string) string {
+	base := strings.TrimSuffix(fullPath, ".go")
+	if filename := base + "_test.go"; !files.Exists(filename) {
+		return filename
+	}
+	return base + "_generated_test.go"
+}
+
+// GenerateTests finds functions that are not covered by tests, asks the AI for table-driven tests for them and
+// writes the tests next to the source files. cfg.CoverProfile is used for finding the uncovered functions, if set.
+// If cfg.RunGeneratedTests is set, test files that do not compile are removed again, or restored if they existed before.
+// Returns the paths of the written test files and the approximate cost in USD.
+func (cfg *Config) GenerateTests(status io.Writer, project *projectinfo.ProjectInfo) ([]string, float64, error) {
+	var (
+		blocks       []CoverBlock
+		totalUSDCost float64
+		written      []string
+		err          error
+	)
+	if cfg.CoverProfile != "" {
+		if blocks, err = ParseCoverProfile(cfg.CoverProfile); err != nil {
+			return nil, 0, err
+		}
+	}
+	uncovered, err := cfg.FindUncoveredFunctions(project, blocks)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(uncovered) == 0 {
+		fmt.Fprintln(status, "No uncovered functions found.")
+		return nil, 0, nil
+	}
+
+	// Group the functions by source file
+	byFile := make(map[string][]UncoveredFunc)
+	for _, fn := range uncovered {
+		byFile[fn.Path] = append(byFile[fn.Path], fn)
+	}
+	paths := make([]string, 0, len(byFile))
+	for path := range byFile {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintf(status, "Found %d uncovered function(s) in %d file(s).\n", len(uncovered), len(paths))
+	if !cfg.Silent {
+		log.Printf("Found %d uncovered function(s) in %d file(s).\n", len(uncovered), len(paths))
+	}
+
+	for i, path := range paths {
+		fns := byFile[path]
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "\n\n// %s\npackage %s\n", filepath.ToSlash(path), fns[0].Package)
+		for _, fn := range fns {
+			sb.WriteString("\n" + fn.Source + "\n")
+		}
+		full, err := SafePath(cfg.Directory, path)
+		if err != nil {
+			return written, totalUSDCost, err
+		}
+		prompt, err := cfg.BuildPrompt(cfg.InitialPrompt, TemplateData{
+			SourceCode:   sb.String(),
+			TestExamples: cfg.testExamples(project, filepath.Dir(filepath.Join(cfg.Directory, path))),
+		})
+		if err != nil {
+			return written, totalUSDCost, err
+		}
+		label := fmt.Sprintf("[tests for %s, %d/%d] ", path, i+1, len(paths))
+		response, usdCost, err := cfg.postAndReport(status, label, prompt, cfg.CountPromptTokens(prompt))
+		totalUSDCost += usdCost
+		if err != nil {
+			fmt.Fprintf(status, "Warning: could not generate tests for %s: %v\n", path, err)
+			continue
+		}
+		testCode := strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response))) + "\n"
+		if !strings.HasPrefix(testCode, "package ") && !strings.HasPrefix(testCode, "//") {
+			fmt.Fprintf(status, "Warning: the response for %s does not look like Go code, skipping it\n", path)
+			continue
+		}
+
+		testFilename := generatedTestFilename(full)
+		previous, readErr := os.ReadFile(testFilename)
+		existed := readErr == nil
+		if existed && !cfg.confirm(testFilename+" already exists. Overwrite it?", "") {
+			fmt.Fprintf(status, "Did not overwrite %s\n", testFilename)
+			continue
+		}
+		if err := cfg.writeOutputFile(testFilename, []byte(testCode)); err != nil {
+			return written, totalUSDCost, fmt.Errorf("failed to write %s: %v", testFilename, err)
+		}
+
+		if cfg.RunGeneratedTests {
+			// Only compile the tests, by running no test functions
+			if output, err := cfg.runCommands(filepath.Dir(testFilename), []string{"go test -count=1 -run ^$ ."}); err != nil {
+				if existed {
+					fmt.Fprintf(status, "Restoring %s, since the new version does not compile: %v\n%s\n", testFilename, err, strings.TrimSpace(output))
+					if err := writeFileAtomic(testFilename, previous, 0644); err != nil {
+						return written, totalUSDCost, fmt.Errorf("could not restore %s: %v", testFilename, err)
+					}
+					continue
+				}
+				fmt.Fprintf(status, "Removing %s, since it does not compile: %v\n%s\n", testFilename, err, strings.TrimSpace(output))
+				if err := os.Remove(testFilename); err != nil {
+					return written, totalUSDCost, err
+				}
+				continue
+			}
+		}
+		fmt.Fprintf(status, "Wrote %s\n", testFilename)
+		written = append(written, testFilename)
+	}
+	return written, totalUSDCost, nil
+}
@@ -0,0 +1,82 @@
+package acode
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// runGit runs git with the given arguments in the given directory, and returns the output
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// gitRoot returns the top level directory of the git repository that contains the given directory
+func gitRoot(dir string) (string, error) {
+	output, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// changedFiles returns the files that are listed in the output of "git diff --name-only",
+// relative to the given directory. Files outside of the directory are skipped.
+func changedFiles(dir, nameOnlyOutput string) ([]string, error) {
+	root, err := gitRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if realDir, err := filepath.EvalSymlinks(absDir); err == nil {
+		absDir = realDir
+	}
+	var changed []string
+	for _, line := range strings.Split(nameOnlyOutput, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		rel, err := filepath.Rel(absDir, filepath.Join(root, filepath.FromSlash(line)))
+		if err != nil || !insideDirectory(rel) {
+			continue
+		}
+		changed = append(changed, rel)
+	}
+	return changed, nil
+}
+
+// limitProjectFiles removes the files from the project that the keep function returns false for.
+// The keep function is given the path relative to cfg.Directory.
+// Returns the files that were removed.
+func (cfg *Config) limitProjectFiles(project *projectinfo.ProjectInfo, keep func(rel string) bool) []projectinfo.FileInfo {
+	var removed []projectinfo.FileInfo
+	filter := func(files []projectinfo.FileInfo) []projectinfo.FileInfo {
+		var kept []projectinfo.FileInfo
+		for _, file := range files {
+			if keep(cfg.relativeProjectPath(file.Path)) {
+				kept = append(kept, file)
+			} else {
+				removed = append(removed, file)
+			}
+		}
+		return kept
+	}
+	project.SourceFiles = filter(project.SourceFiles)
+	project.ConfAndDocFiles = filter(project.ConfAndDocFiles)
+	return removed
+}
@@ -7,6 +7,7 @@ require (
 	github.com/xyproto/env/v2 v2.5.2
 	github.com/xyproto/files v1.8.0
 	github.com/xyproto/projectinfo v1.3.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/xyproto/binary v1.3.3 // indirect
@@ -0,0 +1,314 @@
+package acode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// GodocWidth is the column where generated doc comments are wrapped
+var GodocWidth = 100
+
+// MissingDoc is an exported Go identifier without a doc comment
+type MissingDoc struct {
+	Path        string // relative to Config.Directory
+	Name        string // the identifier, or Type.Method for methods
+	Kind        string // "func", "method", "type", "const" or "var"
+	Line        int
+	Offset      int    // the byte offset of the start of the line where the comment is inserted
+	Indent      string // the indentation of that line
+	Declaration string // the declaration, without function bodies, for use in the prompt
+}
+
+// isGeneratedGoFile checks if the Go source has a "Code generated ... DO NOT EDIT." comment
+func isGeneratedGoFile(f *ast.File) bool {
+	for _, group := range f.Comments {
+		if group.Pos() > f.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated ") && strings.HasSuffix(comment.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isExportedFunc checks if a function or method is exported, and for methods, if the receiver type is exported
+func isExportedFunc(fd *ast.FuncDecl) bool {
+	if !fd.Name.IsExported() {
+		return false
+	}
+	if name := funcName(fd); strings.Contains(name, ".") {
+		return ast.IsExported(strings.SplitN(name, ".", 2)[0])
+	}
+	return true
+}
+
+// nodeSource prints the given node with go/printer, for use in a prompt
+func nodeSource(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// FindMissingDocs finds the exported functions, methods, types, constants and variables in the given Go source
+// that do not have a doc comment. Constants and variables in a group with a doc comment are regarded as documented.
+func FindMissingDocs(path, contents string) ([]MissingDoc, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, contents, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	if isGeneratedGoFile(f) {
+		return nil, nil
+	}
+	var missing []MissingDoc
+	add := func(pos token.Pos, name, kind, declaration string) {
+		position := fset.Position(pos)
+		lineStart := strings.LastIndex(contents[:position.Offset], "\n") + 1
+		indent := contents[lineStart:position.Offset]
+		if strings.TrimSpace(indent) != "" {
+			return // the declaration does not start on a line of its own
+		}
+		missing = append(missing, MissingDoc{
+			Path:        path,
+			Name:        name,
+			Kind:        kind,
+			Line:        position.Line,
+			Offset:      lineStart,
+			Indent:      indent,
+			Declaration: declaration,
+		})
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil || !isExportedFunc(d) {
+				continue
+			}
+			kind := "func"
+			if d.Recv != nil {
+				kind = "method"
+			}
+			signature := *d
+			signature.Body = nil
+			add(d.Pos(), funcName(d), kind, nodeSource(fset, &signature))
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			grouped := d.Lparen.IsValid()
+			if !grouped && d.Doc != nil {
+				continue
+			}
+			if grouped && d.Doc != nil && d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				var (
+					name    string
+					specDoc *ast.CommentGroup
+				)
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					name, specDoc = s.Name.Name, s.Doc
+				case *ast.ValueSpec:
+					for _, ident := range s.Names {
+						if ident.IsExported() {
+							name = ident.Name
+							break
+						}
+					}
+					specDoc = s.Doc
+				}
+				if name == "" || !ast.IsExported(name) || specDoc != nil {
+					continue
+				}
+				pos := spec.Pos()
+				declaration := d.Tok.String() + " " + nodeSource(fset, spec)
+				if !grouped {
+					pos = d.Pos()
+				}
+				add(pos, name, d.Tok.String(), declaration)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// formatDocComment turns the comment text into // lines, wrapped at GodocWidth and indented with the given indentation
+func formatDocComment(text, indent string) string {
+	text = strings.TrimSpace(text)
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		lines = append(lines, line)
+	}
+	width := GodocWidth - len(indent) - len("// ")
+	var sb strings.Builder
+	for _, line := range strings.Split(wrapText(strings.Join(lines, "\n"), width), "\n") {
+		if line == "" {
+			sb.WriteString(indent + "//\n")
+		} else {
+			sb.WriteString(indent + "// " + line + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// docStartsWithName checks if the doc comment starts with the identifier name, optionally after an article,
+// as recommended by Effective Go. For methods, the method name is used.
+func docStartsWithName(text, name string) bool {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "//"))
+	for _, article := range []string{"", "A ", "An ", "The "} {
+		if rest := strings.TrimPrefix(text, article); rest != text || article == "" {
+			if rest == name || strings.HasPrefix(rest, name+" ") || strings.HasPrefix(rest, name+",") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InsertDocComments inserts the doc comments into the Go source, right above the declarations, by splicing the text
+// at the offsets found by FindMissingDocs. This is done instead of adding the comments to the AST and printing it with
+// go/printer, since go/printer reformats the whole file and may move free-floating comments, while splicing leaves
+// every other byte of the file untouched. Comments that do not start with the identifier name are skipped.
+// Returns the new source and the names of the identifiers that got a comment.
+func InsertDocComments(contents string, missing []MissingDoc, comments map[string]string) (string, []string) {
+	sorted := append([]MissingDoc(nil), missing...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset > sorted[j].Offset })
+	var inserted []string
+	for _, md := range sorted {
+		text, ok := comments[md.Name]
+		if !ok || !docStartsWithName(text, md.Name) {
+			continue
+		}
+		contents = contents[:md.Offset] + formatDocComment(text, md.Indent) + contents[md.Offset:]
+		inserted = append(inserted, md.Name)
+	}
+	sort.Strings(inserted)
+	return contents, inserted
+}
+
+// parseDocComments parses the JSON object with doc comments by identifier name from an AI response
+func parseDocComments(response string) (map[string]string, error) {
+	text := strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response)))
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	comments := make(map[string]string)
+	if err := json.Unmarshal([]byte(text), &comments); err != nil {
+		return nil, fmt.Errorf("could not parse the doc comments: %v", err)
+	}
+	return comments, nil
+}
+
+// GenerateGodoc finds exported Go identifiers without doc comments, asks the AI for doc comments, one file at a time,
+// and inserts them. If cfg.DryRun is set, nothing is written, and the diff is returned instead.
+// Otherwise, the changes to each file are confirmed before they are written, and the list of updated files is returned.
+// Also returns the approximate cost in USD.
+func (cfg *Config) GenerateGodoc(status io.Writer, project *projectinfo.ProjectInfo) (string, float64, error) {
+	var (
+		totalUSDCost float64
+		output       strings.Builder
+		count        int
+	)
+	type fileDocs struct {
+		file    projectinfo.FileInfo
+		missing []MissingDoc
+	}
+	var todo []fileDocs
+	for _, file := range project.SourceFiles {
+		if !isGoSource(file.Path) {
+			continue
+		}
+		missing, err := FindMissingDocs(cfg.relativeProjectPath(file.Path), file.Contents)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(missing) > 0 {
+			todo = append(todo, fileDocs{file, missing})
+			count += len(missing)
+		}
+	}
+	if len(todo) == 0 {
+		return "All exported identifiers are documented.", 0, nil
+	}
+	fmt.Fprintf(status, "Found %d exported identifier(s) without doc comments in %d file(s).\n", count, len(todo))
+	if !cfg.Silent {
+		log.Printf("Found %d exported identifier(s) without doc comments in %d file(s).\n", count, len(todo))
+	}
+
+	for i, fd := range todo {
+		rel := fd.missing[0].Path
+		var declarations strings.Builder
+		for _, md := range fd.missing {
+			fmt.Fprintf(&declarations, "\n// %s (%s)\n%s\n", md.Name, md.Kind, md.Declaration)
+		}
+		prompt, err := cfg.BuildPrompt(cfg.InitialPrompt, TemplateData{
+			SourceCode:   "\n\n// " + filepath.ToSlash(rel) + "\n" + fd.file.Contents + "\n",
+			Declarations: declarations.String(),
+		})
+		if err != nil {
+			return output.String(), totalUSDCost, err
+		}
+		label := fmt.Sprintf("[doc comments for %s, %d/%d] ", rel, i+1, len(todo))
+		response, usdCost, err := cfg.postAndReport(status, label, prompt, cfg.CountPromptTokens(prompt))
+		totalUSDCost += usdCost
+		if err != nil {
+			fmt.Fprintf(status, "Warning: could not generate doc comments for %s: %v\n", rel, err)
+			continue
+		}
+		comments, err := parseDocComments(response)
+		if err != nil {
+			fmt.Fprintf(status, "Warning: %s: %v\n", rel, err)
+			continue
+		}
+		updated, inserted := InsertDocComments(fd.file.Contents, fd.missing, comments)
+		if len(inserted) == 0 {
+			continue
+		}
+		// Check that the result still parses and that the comments are now doc comments
+		if stillMissing, err := FindMissingDocs(rel, updated); err != nil || len(stillMissing) != len(fd.missing)-len(inserted) {
+			fmt.Fprintf(status, "Warning: the doc comments for %s could not be inserted cleanly, skipping the file\n", rel)
+			continue
+		}
+		diff := UnifiedDiff(filepath.ToSlash(rel), filepath.ToSlash(rel), fd.file.Contents, updated)
+		if cfg.DryRun {
+			output.WriteString(diff)
+			continue
+		}
+		if !cfg.confirm("Apply these changes to "+rel+"?", diff) {
+			fmt.Fprintf(status, "Did not update %s\n", rel)
+			continue
+		}
+		if err := cfg.writeOutputFile(fd.file.Path, []byte(updated)); err != nil {
+			return output.String(), totalUSDCost, fmt.Errorf("failed to write %s: %v", fd.file.Path, err)
+		}
+		fmt.Fprintf(&output, "%s: documented %s\n", rel, strings.Join(inserted, ", "))
+	}
+	if output.Len() == 0 {
+		return "No doc comments generated.", totalUSDCost, nil
+	}
+	return strings.TrimSuffix(output.String(), "\n"), totalUSDCost, nil
+}
@@ -0,0 +1,120 @@
+package acode
+
+import (
+	"reflect"
+	"testing"
+)
+
+const godocTestSource = `package example
+
+// Documented has a doc comment
+func Documented() {}
+
+func Undocumented() {}
+
+func unexported() {}
+
+type T struct{}
+
+func (T) Method() {}
+
+func (t *T) unexportedMethod() {}
+
+type (
+	// A is documented
+	A int
+	B int
+)
+
+// Limits are documented as a group
+const (
+	Min = 1
+	Max = 2
+)
+
+var Exported, other = 1, 2
+`
+
+func TestFindMissingDocs(t *testing.T) {
+	missing, err := FindMissingDocs("example.go", godocTestSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, md := range missing {
+		got = append(got, md.Kind+" "+md.Name)
+	}
+	want := []string{"func Undocumented", "type T", "method T.Method", "type B", "var Exported"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindMissingDocs() = %q, want %q", got, want)
+	}
+	if _, err := FindMissingDocs("broken.go", "package"); err == nil {
+		t.Error("FindMissingDocs() expected an error for invalid Go source")
+	}
+	generated := "// Code generated by hand. DO NOT EDIT.\n\npackage example\n\nfunc Undocumented() {}\n"
+	if missing, err := FindMissingDocs("generated.go", generated); err != nil || len(missing) != 0 {
+		t.Errorf("FindMissingDocs() = %v, %v for a generated file, want nothing", missing, err)
+	}
+}
+
+func TestInsertDocComments(t *testing.T) {
+	source := "package example\n\nfunc A() {}\n\ntype (\n\tB int\n)\n\nfunc C() {}\n"
+	missing, err := FindMissingDocs("example.go", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := map[string]string{
+		"A": "A does something",
+		"B": "B is a number",
+		"C": "Does not start with the name",
+	}
+	got, inserted := InsertDocComments(source, missing, comments)
+	want := "package example\n\n// A does something\nfunc A() {}\n\ntype (\n\t// B is a number\n\tB int\n)\n\nfunc C() {}\n"
+	if got != want {
+		t.Errorf("InsertDocComments() =\n%q\nwant\n%q", got, want)
+	}
+	if !reflect.DeepEqual(inserted, []string{"A", "B"}) {
+		t.Errorf("InsertDocComments() inserted %q, want %q", inserted, []string{"A", "B"})
+	}
+}
+
+func TestFormatDocComment(t *testing.T) {
+	defer func(width int) { GodocWidth = width }(GodocWidth)
+	GodocWidth = 20
+	tests := []struct {
+		name   string
+		text   string
+		indent string
+		want   string
+	}{
+		{"short", "F does it", "", "// F does it\n"},
+		{"strips comment markers", "// F does it", "\t", "\t// F does it\n"},
+		{"wraps long lines", "F does one thing and then another", "", "// F does one thing\n// and then another\n"},
+		{"keeps paragraphs", "F does it\n\nSee G", "", "// F does it\n//\n// See G\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDocComment(tt.text, tt.indent); got != tt.want {
+				t.Errorf("formatDocComment() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocStartsWithName(t *testing.T) {
+	tests := []struct {
+		text, name string
+		want       bool
+	}{
+		{"Run runs it", "Run", true},
+		{"A Config holds settings", "Config", true},
+		{"Method does it", "T.Method", true},
+		{"Runs it", "Run", false},
+		{"Runner runs it", "Run", false},
+	}
+	for _, tt := range tests {
+		if got := docStartsWithName(tt.text, tt.name); got != tt.want {
+			t.Errorf("docStartsWithName(%q, %q) = %v, want %v", tt.text, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package acode
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// incrementalMode checks if only changed files should be processed
+func (cfg *Config) incrementalMode() bool {
+	return cfg.ChangedSince != "" || cfg.Uncommitted
+}
+
+// changedSinceRef returns the files, relative to cfg.Directory, that differ from cfg.ChangedSince, or from HEAD
+// if only uncommitted changes are wanted. Both committed, staged, unstaged and untracked files are included.
+func (cfg *Config) changedSinceRef() ([]string, error) {
+	ref := cfg.ChangedSince
+	if ref == "" {
+		ref = "HEAD"
+	}
+	diff, err := runGit(cfg.Directory, "diff", "--name-only", "--diff-filter=d", ref, "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := runGit(cfg.Directory, "ls-files", "--others", "--exclude-standard", "--full-name", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	return changedFiles(cfg.Directory, diff+"\n"+untracked)
+}
+
+// dependentDirs returns the directories of the Go packages in the project that directly import
+// a package in one of the given directories. The directories are relative to cfg.Directory.
+func (cfg *Config) dependentDirs(project *projectinfo.ProjectInfo, dirs map[string]bool) map[string]bool {
+	dependents := make(map[string]bool)
+	graph, err := cfg.BuildImportGraph(project)
+	if err != nil {
+		return dependents // not a Go project, or it could not be parsed
+	}
+	dirByID := make(map[string]string)
+	for _, pkg := range graph.Packages {
+		if !pkg.External {
+			dirByID[pkg.ID] = filepath.FromSlash(pkg.Dir)
+		}
+	}
+	for _, edge := range graph.Edges {
+		from, fromOK := dirByID[edge.From]
+		to, toOK := dirByID[edge.To]
+		if fromOK && toOK && dirs[to] && !dirs[from] {
+			dependents[from] = true
+		}
+	}
+	return dependents
+}
+
+// limitToChangedFiles removes the files that have not changed since cfg.ChangedSince, or that have no uncommitted
+// changes, from the project. If cfg.IncludeDependents is set, the files of Go packages that directly import a changed
+// package are kept as well. The skipped files and tokens are recorded, so that the savings can be reported.
+func (cfg *Config) limitToChangedFiles(project *projectinfo.ProjectInfo) error {
+	changed, err := cfg.changedSinceRef()
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	changedDirs := make(map[string]bool)
+	for _, rel := range changed {
+		keep[rel] = true
+		changedDirs[filepath.Dir(rel)] = true
+	}
+	var dependents map[string]bool
+	if cfg.IncludeDependents {
+		dependents = cfg.dependentDirs(project, changedDirs)
+	}
+	removed := cfg.limitProjectFiles(project, func(rel string) bool {
+		return keep[rel] || (dependents[filepath.Dir(rel)] && isGoSource(rel))
+	})
+	cfg.skippedFiles = nil
+	cfg.skippedTokens = 0
+	for _, file := range removed {
+		cfg.skippedFiles = append(cfg.skippedFiles, cfg.relativeProjectPath(file.Path))
+		cfg.skippedTokens += projectinfo.CountTokens(file.Contents)
+	}
+	sort.Strings(cfg.skippedFiles)
+	if !cfg.Silent {
+		since := "uncommitted changes"
+		if cfg.ChangedSince != "" {
+			since = "changes since " + cfg.ChangedSince
+		}
+		if len(dependents) > 0 {
+			since += ", and their dependents in " + strings.Join(sortedSet(dependents), ", ")
+		}
+		kept := len(project.SourceFiles) + len(project.ConfAndDocFiles)
+		log.Printf("Incremental mode: processing %d file(s) with %s\n", kept, since)
+		log.Printf("%s\n", cfg.skippedSummary())
+	}
+	return nil
+}
+
+// sortedSet returns the keys of the set, sorted
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// skippedSummary describes how many files were skipped by the incremental mode, and the estimated savings
+func (cfg *Config) skippedSummary() string {
+	phases := 1
+	if cfg.AlsoOutputFixAndConfidence {
+		phases = 3 // the initial, fix and confidence prompts all include the source code
+	}
+	savedUSD := cfg.Model.CalculateCost(cfg.skippedTokens*phases, 0)
+	return fmt.Sprintf("Skipped %d unchanged file(s), about %d tokens per prompt phase, saving approximately $%.2f.", len(cfg.skippedFiles), cfg.skippedTokens, savedUSD)
+}
+
+// reportSkippedFiles writes the files that were skipped by the incremental mode, and the estimated savings, to status
+func (cfg *Config) reportSkippedFiles(status io.Writer) {
+	if !cfg.incrementalMode() {
+		return
+	}
+	fmt.Fprintln(status, cfg.skippedSummary())
+	for _, path := range cfg.skippedFiles {
+		fmt.Fprintf(status, "  skipped: %s\n", path)
+	}
+}
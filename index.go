@@ -0,0 +1,359 @@
+package acode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/projectinfo"
+)
+
+const (
+	// indexVersion is increased when the format of the search index changes, so that old indexes are rebuilt
+	indexVersion = 1
+
+	// bm25K1 and bm25B are the usual BM25 parameters for term frequency saturation and length normalization
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SegmentLines is the number of lines in each indexed file segment
+var SegmentLines = 60
+
+// IndexedSegment is a range of lines from a file, with its term frequencies
+type IndexedSegment struct {
+	StartLine int            `json:"start"`
+	EndLine   int            `json:"end"`
+	Text      string         `json:"text"`
+	Length    int            `json:"length"` // the number of terms
+	Terms     map[string]int `json:"terms"`
+}
+
+// IndexedFile is a file in the search index, with the hash of the contents that were indexed
+type IndexedFile struct {
+	Hash     string           `json:"hash"`
+	Segments []IndexedSegment `json:"segments"`
+}
+
+// SearchIndex is a BM25 index over segments of the project files
+type SearchIndex struct {
+	Version      int                     `json:"version"`
+	Files        map[string]*IndexedFile `json:"files"` // by path, relative to the project directory
+	DocFreq      map[string]int          `json:"docfreq"`
+	SegmentCount int                     `json:"segments"`
+	TotalLength  int                     `json:"length"`
+}
+
+// SearchResult is a file segment that matches a query
+type SearchResult struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	Text      string
+	Score     float64
+}
+
+// IndexUpdate tells how many files were added, updated, removed and left as they were by an index update
+type IndexUpdate struct {
+	Added, Updated, Removed, Unchanged int
+}
+
+// NewSearchIndex returns an empty search index
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{Version: indexVersion, Files: make(map[string]*IndexedFile), DocFreq: make(map[string]int)}
+}
+
+// splitIdentifier splits an identifier like "parseHTTPRequest" or "max_token_count" into its lowercase parts
+func splitIdentifier(word string) []string {
+	var (
+		parts   []string
+		current []rune
+		runes   = []rune(word)
+	)
+	flush := func() {
+		if len(current) > 0 {
+			parts = append(parts, strings.ToLower(string(current)))
+			current = nil
+		}
+	}
+	for i, r := range runes {
+		if r == '_' {
+			flush()
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// Split before an upper case letter that follows a lower case letter or a digit, like "parse|HTTP",
+			// and before the last upper case letter of an acronym that is followed by a lower case letter, like "HTTP|Request"
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+	return parts
+}
+
+// Tokenize returns the search terms in the text. Each word is included in lower case, and identifiers
+// are also split into their camelCase and snake_case parts. Terms shorter than two characters are left out.
+func Tokenize(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		word = strings.Trim(word, "_")
+		if len(word) < 2 {
+			continue
+		}
+		lower := strings.ToLower(word)
+		terms = append(terms, lower)
+		if parts := splitIdentifier(word); len(parts) > 1 {
+			for _, part := range parts {
+				if len(part) >= 2 && part != lower {
+					terms = append(terms, part)
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// contentHash returns the SHA-256 hash of the contents, as a hex string
+func contentHash(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+// segmentFile splits the contents into segments of SegmentLines lines, and counts the terms in each segment
+func segmentFile(contents string) []IndexedSegment {
+	lines := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	var segments []IndexedSegment
+	for start := 0; start < len(lines); start += SegmentLines {
+		end := start + SegmentLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+		text := strings.Join(lines[start:end], "\n")
+		terms := Tokenize(text)
+		if len(terms) == 0 {
+			continue
+		}
+		segment := IndexedSegment{StartLine: start + 1, EndLine: end, Text: text, Length: len(terms), Terms: make(map[string]int)}
+		for _, term := range terms {
+			segment.Terms[term]++
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// addFile adds the segments of a file to the document frequencies and totals
+func (idx *SearchIndex) addFile(path string, file *IndexedFile) {
+	idx.Files[path] = file
+	for _, segment := range file.Segments {
+		for term := range segment.Terms {
+			idx.DocFreq[term]++
+		}
+		idx.SegmentCount++
+		idx.TotalLength += segment.Length
+	}
+}
+
+// removeFile removes a file and its segments from the document frequencies and totals
+func (idx *SearchIndex) removeFile(path string) {
+	file, ok := idx.Files[path]
+	if !ok {
+		return
+	}
+	for _, segment := range file.Segments {
+		for term := range segment.Terms {
+			if idx.DocFreq[term]--; idx.DocFreq[term] <= 0 {
+				delete(idx.DocFreq, term)
+			}
+		}
+		idx.SegmentCount--
+		idx.TotalLength -= segment.Length
+	}
+	delete(idx.Files, path)
+}
+
+// Update indexes the files in the project that are new or that have changed since they were indexed,
+// by comparing content hashes, and removes files that are no longer in the project.
+// The paths are made relative with the given function.
+func (idx *SearchIndex) Update(files []projectinfo.FileInfo, relativePath func(string) string) IndexUpdate {
+	var update IndexUpdate
+	seen := make(map[string]bool)
+	for _, file := range files {
+		path := filepath.ToSlash(relativePath(file.Path))
+		seen[path] = true
+		hash := contentHash(file.Contents)
+		existing, ok := idx.Files[path]
+		if ok && existing.Hash == hash {
+			update.Unchanged++
+			continue
+		}
+		if ok {
+			idx.removeFile(path)
+			update.Updated++
+		} else {
+			update.Added++
+		}
+		idx.addFile(path, &IndexedFile{Hash: hash, Segments: segmentFile(file.Contents)})
+	}
+	for path := range idx.Files {
+		if !seen[path] {
+			idx.removeFile(path)
+			update.Removed++
+		}
+	}
+	return update
+}
+
+// Search returns up to k file segments that match the query best, according to BM25, the best match first.
+// If tokenBudget is larger than 0, segments are only included as long as their estimated token count fits within it.
+func (idx *SearchIndex) Search(query string, k, tokenBudget int) []SearchResult {
+	if idx.SegmentCount == 0 {
+		return nil
+	}
+	queryTerms := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if !chatStopWords[term] {
+			queryTerms[term] = true
+		}
+	}
+	var (
+		results   []SearchResult
+		avgLength = float64(idx.TotalLength) / float64(idx.SegmentCount)
+		n         = float64(idx.SegmentCount)
+	)
+	for path, file := range idx.Files {
+		for _, segment := range file.Segments {
+			score := 0.0
+			for term := range queryTerms {
+				tf := float64(segment.Terms[term])
+				if tf == 0 {
+					continue
+				}
+				df := float64(idx.DocFreq[term])
+				idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+				score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(segment.Length)/avgLength))
+			}
+			if score > 0 {
+				results = append(results, SearchResult{Path: path, StartLine: segment.StartLine, EndLine: segment.EndLine, Text: segment.Text, Score: score})
+			}
+		}
+	}
+	sortSearchResults(results)
+	return withinBudget(results, k, tokenBudget)
+}
+
+// sortSearchResults sorts the results by score, the best match first, and then by path and line
+func sortSearchResults(results []SearchResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].StartLine < results[j].StartLine
+	})
+}
+
+// withinBudget returns up to k of the sorted results. If tokenBudget is larger than 0, results are only
+// included as long as their estimated token count fits within it.
+func withinBudget(results []SearchResult, k, tokenBudget int) []SearchResult {
+	var (
+		selected []SearchResult
+		used     int
+	)
+	for _, result := range results {
+		if len(selected) >= k {
+			break
+		}
+		tokens := projectinfo.CountTokens(result.Text)
+		if tokenBudget > 0 && used+tokens > tokenBudget {
+			continue
+		}
+		used += tokens
+		selected = append(selected, result)
+	}
+	return selected
+}
+
+// LoadSearchIndex reads a search index from disk. If the file does not exist, or was written by an
+// incompatible version, an empty index is returned.
+func LoadSearchIndex(filename string) (*SearchIndex, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return NewSearchIndex(), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read the search index: %v", err)
+	}
+	var idx SearchIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("could not parse the search index %s: %v", filename, err)
+	}
+	if idx.Version != indexVersion || idx.Files == nil || idx.DocFreq == nil {
+		return NewSearchIndex(), nil
+	}
+	return &idx, nil
+}
+
+// Save writes the search index to disk atomically
+func (idx *SearchIndex) Save(filename string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("could not encode the search index: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("could not create the directory for the search index: %v", err)
+	}
+	return writeFileAtomic(filename, data, 0644)
+}
+
+// indexFilename returns where the search index is stored, which is .acode/index.json in the project directory by default
+func (cfg *Config) indexFilename() string {
+	if cfg.IndexFile != "" {
+		return cfg.IndexFile
+	}
+	return filepath.Join(cfg.Directory, ".acode", "index.json")
+}
+
+// OpenSearchIndex loads the search index for the project, brings it up to date with the project files,
+// and saves it again if anything changed
+func (cfg *Config) OpenSearchIndex(project *projectinfo.ProjectInfo) (*SearchIndex, IndexUpdate, error) {
+	filename := cfg.indexFilename()
+	idx, err := LoadSearchIndex(filename)
+	if err != nil {
+		return nil, IndexUpdate{}, err
+	}
+	update := idx.Update(project.AllFiles(), cfg.relativeProjectPath)
+	if update.Added > 0 || update.Updated > 0 || update.Removed > 0 {
+		if err := idx.Save(filename); err != nil {
+			return idx, update, fmt.Errorf("could not save the search index: %v", err)
+		}
+	}
+	return idx, update, nil
+}
+
+// FormatSearchResults returns the segments with line numbers and a "path:START-END" header, for use in a prompt
+func FormatSearchResults(results []SearchResult) string {
+	var sb strings.Builder
+	for _, result := range results {
+		fmt.Fprintf(&sb, "\n// %s:%d-%d\n", result.Path, result.StartLine, result.EndLine)
+		for i, line := range strings.Split(result.Text, "\n") {
+			fmt.Fprintf(&sb, "%d| %s\n", result.StartLine+i, line)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package acode
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xyproto/projectinfo"
+)
+
+func TestSplitIdentifier(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"parseHTTPRequest", []string{"parse", "http", "request"}},
+		{"max_token_count", []string{"max", "token", "count"}},
+		{"HTTPServer", []string{"http", "server"}},
+		{"sha256Sum", []string{"sha256", "sum"}},
+		{"simple", []string{"simple"}},
+	}
+	for _, tt := range tests {
+		if got := splitIdentifier(tt.word); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitIdentifier(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"func parseHTTPRequest(r *Request)", []string{"func", "parsehttprequest", "parse", "http", "request", "request"}},
+		{"a x_ _max_count", []string{"max_count", "max", "count"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	relative := func(path string) string { return path }
+	idx := NewSearchIndex()
+	files := []projectinfo.FileInfo{
+		{Path: "server.go", Contents: "func startServer() {\n\tlistenAndServe(port)\n}\n"},
+		{Path: "tokens.go", Contents: "func countTokens(text string) int {\n\treturn len(text) / 4\n}\n"},
+		{Path: "empty.go", Contents: "\n"},
+	}
+	if got, want := idx.Update(files, relative), (IndexUpdate{Added: 3}); got != want {
+		t.Errorf("Update() = %+v, want %+v", got, want)
+	}
+
+	results := idx.Search("how are tokens counted", 10, 0)
+	if len(results) != 1 || results[0].Path != "tokens.go" || results[0].StartLine != 1 || results[0].EndLine != 3 {
+		t.Errorf("Search() = %+v, want one result for tokens.go, lines 1-3", results)
+	}
+	if results := idx.Search("the", 10, 0); len(results) != 0 {
+		t.Errorf("Search() with only stop words = %+v, want no results", results)
+	}
+	if results := idx.Search("server tokens", 10, 1); len(results) != 0 {
+		t.Errorf("Search() with a tiny token budget = %+v, want no results", results)
+	}
+
+	files[0].Contents = "func stopServer() {}\n"
+	update := idx.Update(files[:2], relative)
+	if want := (IndexUpdate{Updated: 1, Removed: 1, Unchanged: 1}); update != want {
+		t.Errorf("Update() = %+v, want %+v", update, want)
+	}
+	if results := idx.Search("start", 10, 0); len(results) != 0 {
+		t.Errorf("Search() for a removed term = %+v, want no results", results)
+	}
+	if results := idx.Search("stop", 10, 0); len(results) != 1 || results[0].Path != "server.go" {
+		t.Errorf("Search() for an updated term = %+v, want server.go", results)
+	}
+
+	filename := filepath.Join(t.TempDir(), "index.json")
+	if err := idx.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSearchIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, idx) {
+		t.Error("LoadSearchIndex() did not return the saved index")
+	}
+}
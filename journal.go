@@ -0,0 +1,269 @@
+package acode
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// The phases of Process, as recorded in the run journal
+const (
+	PhaseInitial    = "initial"
+	PhaseFix        = "fix"
+	PhaseConfidence = "confidence"
+)
+
+const journalVersion = 1
+
+// JournalEntry is a chunk that has been processed in one of the phases of a run
+type JournalEntry struct {
+	Phase     string    `json:"phase"`
+	Chunk     int       `json:"chunk"`
+	Hash      string    `json:"hash"` // of the prompt, with the chunk and the previous answer filled in
+	Response  string    `json:"response"`
+	Model     string    `json:"model"`
+	USDCost   float64   `json:"usdCost"`
+	Completed time.Time `json:"completed"`
+}
+
+// RunJournal records the processed chunks of a run, so that an interrupted run can be resumed
+type RunJournal struct {
+	Version    int            `json:"version"`
+	RunID      string         `json:"runID"`
+	OpType     OperationType  `json:"opType"`
+	Model      string         `json:"model"`
+	Started    time.Time      `json:"started"`
+	Finished   bool           `json:"finished"`
+	Entries    []JournalEntry `json:"entries"`
+	filename   string
+	entryIndex map[string]int // the index of each entry in Entries, by phase, chunk and hash
+}
+
+// journalDirectory returns the directory where the run journals are stored
+func (cfg *Config) journalDirectory() string {
+	if cfg.JournalDir != "" {
+		return cfg.JournalDir
+	}
+	return filepath.Join(cfg.Directory, ".acode", "runs")
+}
+
+// journalKey returns the key of an entry in the run journal
+func journalKey(phase string, chunk int, hash string) string {
+	return fmt.Sprintf("%s/%d/%s", phase, chunk, hash)
+}
+
+// promptHash returns a hash of the rendered prompt for a chunk. The prompt contains everything that the response
+// depends on, apart from the model, including the data that is added for some operations, like a diff or the commits.
+func promptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRunID returns an ID for a new run, which is the start time followed by random hex digits,
+// so that runs that are started within the same second do not share a journal
+func newRunID(started time.Time) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate a run ID: %v", err)
+	}
+	return started.Format("20060102-150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// LoadRunJournal reads the run journal from the given file
+func LoadRunJournal(filename string) (*RunJournal, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the run journal %s: %v", filename, err)
+	}
+	var journal RunJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("could not parse the run journal %s: %v", filename, err)
+	}
+	if journal.Version != journalVersion {
+		return nil, fmt.Errorf("the run journal %s has version %d, expected version %d", filename, journal.Version, journalVersion)
+	}
+	journal.filename = filename
+	journal.entryIndex = make(map[string]int, len(journal.Entries))
+	for i, entry := range journal.Entries {
+		journal.entryIndex[journalKey(entry.Phase, entry.Chunk, entry.Hash)] = i
+	}
+	return &journal, nil
+}
+
+// Save writes the run journal to its file
+func (journal *RunJournal) Save() error {
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal the run journal: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(journal.filename), 0755); err != nil {
+		return fmt.Errorf("could not create the directory for the run journal: %v", err)
+	}
+	if err := writeFileAtomic(journal.filename, data, 0644); err != nil {
+		return fmt.Errorf("could not write the run journal %s: %v", journal.filename, err)
+	}
+	return nil
+}
+
+// Lookup returns the journal entry for the given chunk in the given phase, if it has been processed
+func (journal *RunJournal) Lookup(phase string, chunk int, hash string) (JournalEntry, bool) {
+	i, ok := journal.entryIndex[journalKey(phase, chunk, hash)]
+	if !ok {
+		return JournalEntry{}, false
+	}
+	return journal.Entries[i], true
+}
+
+// Record adds a processed chunk to the run journal and saves it
+func (journal *RunJournal) Record(entry JournalEntry) error {
+	key := journalKey(entry.Phase, entry.Chunk, entry.Hash)
+	if i, ok := journal.entryIndex[key]; ok {
+		journal.Entries[i] = entry
+	} else {
+		journal.entryIndex[key] = len(journal.Entries)
+		journal.Entries = append(journal.Entries, entry)
+	}
+	return journal.Save()
+}
+
+// latestUnfinishedRun returns the ID of the most recently started run for the current operation that did not finish
+func (cfg *Config) latestUnfinishedRun() (string, error) {
+	matches, err := filepath.Glob(filepath.Join(cfg.journalDirectory(), "*.json"))
+	if err != nil {
+		return "", err
+	}
+	var journals []*RunJournal
+	for _, filename := range matches {
+		journal, err := LoadRunJournal(filename)
+		if err != nil || journal.Finished || journal.OpType != cfg.OpType {
+			continue
+		}
+		journals = append(journals, journal)
+	}
+	if len(journals) == 0 {
+		return "", fmt.Errorf("found no unfinished runs to resume in %s", cfg.journalDirectory())
+	}
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].Started.After(journals[j].Started)
+	})
+	return journals[0].RunID, nil
+}
+
+// openJournal opens the run journal for cfg.RunID if cfg.Resume is set, or else starts a new one if cfg.Journal is set.
+// If cfg.Resume is set and there is no cfg.RunID, the latest unfinished run for the same operation is resumed.
+// A new run gets a new run ID if cfg.RunID is blank, without changing cfg.RunID, so that the next run gets a new one too.
+func (cfg *Config) openJournal(status io.Writer) error {
+	cfg.journal = nil
+	if !cfg.Journal && !cfg.Resume {
+		return nil
+	}
+	if strings.ContainsAny(cfg.RunID, `/\`) {
+		return fmt.Errorf("invalid run ID: %s", cfg.RunID)
+	}
+	runID := cfg.RunID
+	if cfg.Resume {
+		if runID == "" {
+			latest, err := cfg.latestUnfinishedRun()
+			if err != nil {
+				return err
+			}
+			runID = latest
+		}
+		journal, err := LoadRunJournal(filepath.Join(cfg.journalDirectory(), runID+".json"))
+		if err != nil {
+			return err
+		}
+		if journal.OpType != cfg.OpType {
+			return fmt.Errorf("run %s was started for a different operation", runID)
+		}
+		cfg.journal = journal
+		fmt.Fprintf(status, "Resuming run %s, with %d chunk(s) already processed.\n", runID, len(journal.Entries))
+		if !cfg.Silent {
+			log.Printf("Resuming run %s, with %d chunk(s) already processed.\n", runID, len(journal.Entries))
+		}
+		return nil
+	}
+	started := time.Now()
+	if runID == "" {
+		var err error
+		if runID, err = newRunID(started); err != nil {
+			return err
+		}
+	}
+	cfg.journal = &RunJournal{
+		Version:    journalVersion,
+		RunID:      runID,
+		OpType:     cfg.OpType,
+		Model:      cfg.Model.Name,
+		Started:    started,
+		filename:   filepath.Join(cfg.journalDirectory(), runID+".json"),
+		entryIndex: make(map[string]int),
+	}
+	fmt.Fprintf(status, "Starting run %s.\n", runID)
+	if !cfg.Silent {
+		log.Printf("Starting run %s.\n", runID)
+	}
+	return cfg.journal.Save()
+}
+
+// finishJournal marks the current run as finished, so that it is not resumed
+func (cfg *Config) finishJournal() {
+	if cfg.journal == nil {
+		return
+	}
+	cfg.journal.Finished = true
+	if err := cfg.journal.Save(); err != nil && !cfg.Silent {
+		log.Printf("warning: %v\n", err)
+	}
+	cfg.journal = nil
+}
+
+// processJournaledChunk processes a chunk with ProcessChunk, unless the run journal already has the response for it.
+// New responses are recorded in the run journal, so that the run can be resumed if it is interrupted.
+func (cfg *Config) processJournaledChunk(status io.Writer, phase string, i, n int, project *projectinfo.ProjectInfo, jsonChunk, promptTemplate, previousAIAnswer string) (string, float64, error) {
+	if cfg.journal == nil {
+		return cfg.ProcessChunk(status, i, n, project, jsonChunk, promptTemplate, previousAIAnswer)
+	}
+	prompt, err := cfg.BuildPrompt(promptTemplate, cfg.templateData(project, jsonChunk, previousAIAnswer))
+	if err != nil {
+		return "", 0, err
+	}
+	hash := promptHash(prompt)
+	if entry, ok := cfg.journal.Lookup(phase, i, hash); ok {
+		fmt.Fprintf(status, "Chunk %d of %d was already processed in the %s phase of run %s (by %s, for $%.2f), skipping.\n", i+1, n, phase, cfg.journal.RunID, entry.Model, entry.USDCost)
+		if !cfg.Silent {
+			log.Printf("Chunk %d of %d was already processed in the %s phase of run %s, skipping.\n", i+1, n, phase, cfg.journal.RunID)
+		}
+		return entry.Response, 0, nil
+	}
+	response, usdCost, err := cfg.processPrompt(status, i, n, prompt)
+	if err != nil {
+		return response, usdCost, err
+	}
+	if err := cfg.journal.Record(JournalEntry{
+		Phase:     phase,
+		Chunk:     i,
+		Hash:      hash,
+		Response:  response,
+		Model:     cfg.lastModelName,
+		USDCost:   usdCost,
+		Completed: time.Now(),
+	}); err != nil {
+		fmt.Fprintf(status, "Warning: %v\n", err)
+		if !cfg.Silent {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
+	return response, usdCost, nil
+}
@@ -0,0 +1,233 @@
+package acode
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The markers that fence regions of a Markdown document that should never be changed when merging
+const (
+	PreserveStartMarker = "<!-- acode:preserve -->"
+	PreserveEndMarker   = "<!-- acode:end -->"
+)
+
+var (
+	headingRegexp = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	badgeRegexp   = regexp.MustCompile(`^\s*\[?!\[`)
+)
+
+// mdSection is a part of a Markdown document that starts with a heading, or a region fenced by preserve markers
+type mdSection struct {
+	key       string // the normalized heading, with a counter for repeated headings, or blank for the text before the first heading
+	lines     []string
+	preserved bool
+}
+
+// sectionKey normalizes a heading so that small changes in formatting do not prevent matching sections
+func sectionKey(level int, title string) string {
+	title = strings.ToLower(strings.Join(strings.Fields(strings.Trim(title, "*_` ")), " "))
+	return fmt.Sprintf("%d %s", level, title)
+}
+
+// parseSections splits a Markdown document into sections by heading. Headings in code blocks are ignored.
+func parseSections(doc string) []mdSection {
+	var (
+		sections  []mdSection
+		current   = mdSection{}
+		inFence   bool
+		preserved bool
+		counts    = make(map[string]int)
+		regions   int
+	)
+	flush := func() {
+		if len(current.lines) > 0 || current.key != "" {
+			sections = append(sections, current)
+		}
+	}
+	for _, line := range splitLines(doc) {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case preserved:
+			current.lines = append(current.lines, line)
+			if trimmed == PreserveEndMarker {
+				preserved = false
+				flush()
+				// Text after a preserved region, but before the next heading, is kept as it is
+				regions++
+				current = mdSection{key: fmt.Sprintf("\x00after preserved region %d", regions)}
+			}
+			continue
+		case trimmed == PreserveStartMarker:
+			flush()
+			current = mdSection{lines: []string{line}, preserved: true}
+			preserved = true
+			continue
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			inFence = !inFence
+		case !inFence:
+			if m := headingRegexp.FindStringSubmatch(line); m != nil {
+				flush()
+				key := sectionKey(len(m[1]), m[2])
+				counts[key]++
+				if counts[key] > 1 {
+					key = fmt.Sprintf("%s #%d", key, counts[key])
+				}
+				current = mdSection{key: key}
+			}
+		}
+		current.lines = append(current.lines, line)
+	}
+	flush()
+	return sections
+}
+
+// keepBadges returns the new section lines, with badge lines from the old section added after the heading if they are missing
+func keepBadges(oldLines, newLines []string) []string {
+	present := make(map[string]bool)
+	for _, line := range newLines {
+		present[strings.TrimSpace(line)] = true
+	}
+	var badges []string
+	for _, line := range oldLines {
+		if badgeRegexp.MatchString(line) && !present[strings.TrimSpace(line)] {
+			badges = append(badges, line)
+		}
+	}
+	if len(badges) == 0 {
+		return newLines
+	}
+	insertAt := 0
+	if len(newLines) > 0 && headingRegexp.MatchString(newLines[0]) {
+		insertAt = 1
+		badges = append([]string{""}, badges...)
+	}
+	result := append([]string{}, newLines[:insertAt]...)
+	result = append(result, badges...)
+	return append(result, newLines[insertAt:]...)
+}
+
+// MergeMarkdown merges a newly generated Markdown document into an existing one, section by section.
+// Regions fenced by PreserveStartMarker and PreserveEndMarker are kept as they are, and so are sections
+// that only exist in the existing document. Sections that exist in both are replaced by the generated
+// version, but badges from the existing section are kept. Generated sections that are new are inserted
+// after the section they follow in the generated document.
+func MergeMarkdown(existing, generated string) string {
+	var (
+		oldSections = parseSections(existing)
+		newSections = parseSections(generated)
+		newByKey    = make(map[string]mdSection)
+		used        = make(map[string]bool)
+		merged      []mdSection
+	)
+	for _, s := range newSections {
+		if !s.preserved {
+			newByKey[s.key] = s
+		}
+	}
+	for _, s := range oldSections {
+		if generatedSection, ok := newByKey[s.key]; ok && !s.preserved && !used[s.key] {
+			used[s.key] = true
+			merged = append(merged, mdSection{key: s.key, lines: keepBadges(s.lines, generatedSection.lines)})
+			continue
+		}
+		merged = append(merged, s)
+	}
+	// Insert the generated sections that did not exist before
+	for i, s := range newSections {
+		if s.preserved || used[s.key] {
+			continue
+		}
+		used[s.key] = true
+		insertAt := len(merged)
+		if i == 0 {
+			insertAt = 0
+		} else {
+			for j := range merged {
+				if !merged[j].preserved && merged[j].key == newSections[i-1].key {
+					insertAt = j + 1
+				}
+			}
+		}
+		merged = append(merged[:insertAt], append([]mdSection{s}, merged[insertAt:]...)...)
+	}
+	var lines []string
+	for _, s := range merged {
+		// A generated section may lack the blank line that separated the replaced section from the next heading
+		if len(lines) > 0 && len(s.lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" && headingRegexp.MatchString(s.lines[0]) {
+			lines = append(lines, "")
+		}
+		lines = append(lines, s.lines...)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// fileSectionKey returns the section key of the documentation for the given file, which has a "## path" heading
+func fileSectionKey(path string) string {
+	return sectionKey(2, path)
+}
+
+// demoteHeadings turns the headings in a Markdown document into bold lines, so that the document can be placed
+// in a single section of another document. Headings in code blocks are left as they are.
+func demoteHeadings(doc string) string {
+	var (
+		lines   = splitLines(doc)
+		inFence bool
+	)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if m := headingRegexp.FindStringSubmatch(line); m != nil && !inFence {
+			lines[i] = "**" + strings.Trim(m[2], "*_ ") + "**"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// MergeFileSections merges the documentation for some of the project files into an existing Markdown document.
+// The documentation for each file is placed in a section with the path as a "## " heading, and any headings in it
+// are turned into bold lines. Existing sections for the same files are replaced, and the sections for files that
+// were not documented before are added to the end of the document, sorted by path. All other sections are kept.
+func MergeFileSections(existing string, docs map[string]string) string {
+	fileSection := func(path string) []string {
+		lines := []string{"## " + path, ""}
+		return append(lines, splitLines(strings.TrimSpace(demoteHeadings(docs[path])))...)
+	}
+	byKey := make(map[string]string, len(docs))
+	for path := range docs {
+		byKey[fileSectionKey(path)] = path
+	}
+	var (
+		lines []string
+		used  = make(map[string]bool)
+	)
+	add := func(sectionLines []string) {
+		if len(lines) > 0 && len(sectionLines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, sectionLines...)
+	}
+	for _, s := range parseSections(existing) {
+		if path, ok := byKey[s.key]; ok && !s.preserved && !used[path] {
+			used[path] = true
+			add(fileSection(path))
+			continue
+		}
+		add(s.lines)
+	}
+	paths := make([]string, 0, len(docs))
+	for path := range docs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if !used[path] {
+			add(fileSection(path))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
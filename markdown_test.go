@@ -0,0 +1,102 @@
+package acode
+
+import "testing"
+
+func TestMergeMarkdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  string
+		generated string
+		want      string
+	}{
+		{
+			name:      "replaces matching sections and keeps the others",
+			existing:  "# Project\n\nOld intro.\n\n## Usage\n\nOld usage.\n\n## Custom\n\nHand written.\n",
+			generated: "# Project\n\nNew intro.\n\n## Usage\n\nNew usage.\n",
+			want:      "# Project\n\nNew intro.\n\n## Usage\n\nNew usage.\n\n## Custom\n\nHand written.\n",
+		},
+		{
+			name:      "inserts new sections after the section they follow",
+			existing:  "# Project\n\nIntro.\n\n## License\n\nMIT\n",
+			generated: "# Project\n\nIntro.\n\n## Installation\n\ngo install\n\n## License\n\nMIT\n",
+			want:      "# Project\n\nIntro.\n\n## Installation\n\ngo install\n\n## License\n\nMIT\n",
+		},
+		{
+			name:      "keeps preserved regions",
+			existing:  "# Project\n\n<!-- acode:preserve -->\nKeep me.\n<!-- acode:end -->\n\n## Usage\n\nOld.\n",
+			generated: "# Project\n\n## Usage\n\nNew.\n",
+			want:      "# Project\n\n<!-- acode:preserve -->\nKeep me.\n<!-- acode:end -->\n\n## Usage\n\nNew.\n",
+		},
+		{
+			name:      "keeps badges",
+			existing:  "# Project\n\n[![Build](https://example.com/badge.svg)](https://example.com)\n\nOld.\n",
+			generated: "# Project\n\nNew.\n",
+			want:      "# Project\n\n[![Build](https://example.com/badge.svg)](https://example.com)\n\nNew.\n",
+		},
+		{
+			name:      "ignores headings in code blocks and formatting differences",
+			existing:  "## **Usage**\n\nOld.\n\n```sh\n# not a heading\n```\n",
+			generated: "## Usage\n\nNew.\n",
+			want:      "## Usage\n\nNew.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeMarkdown(tt.existing, tt.generated); got != tt.want {
+				t.Errorf("MergeMarkdown() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSections(t *testing.T) {
+	doc := "Preamble\n# A\ntext\n## B\n```\n# code\n```\n## B\n"
+	var keys []string
+	for _, s := range parseSections(doc) {
+		keys = append(keys, s.key)
+	}
+	want := []string{"", "1 a", "2 b", "2 b #2"}
+	if len(keys) != len(want) {
+		t.Fatalf("parseSections() keys = %q, want %q", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("parseSections() keys = %q, want %q", keys, want)
+		}
+	}
+}
+
+func TestMergeFileSections(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		docs     map[string]string
+		want     string
+	}{
+		{
+			name:     "adds sections for new files, sorted by path",
+			existing: "# Project\n\nOverview.\n",
+			docs:     map[string]string{"b.go": "B does things.", "a.go": "# A\n\nA does things."},
+			want:     "# Project\n\nOverview.\n\n## a.go\n\n**A**\n\nA does things.\n\n## b.go\n\nB does things.\n",
+		},
+		{
+			name:     "replaces the sections for changed files and keeps the rest",
+			existing: "# Project\n\n## a.go\n\nOld A.\n\n## b.go\n\nOld B.\n",
+			docs:     map[string]string{"a.go": "New A.\n\n```md\n# not a heading\n```"},
+			want:     "# Project\n\n## a.go\n\nNew A.\n\n```md\n# not a heading\n```\n\n## b.go\n\nOld B.\n",
+		},
+		{
+			name:     "creates the document",
+			existing: "",
+			docs:     map[string]string{"main.go": "## Usage\nRun it."},
+			want:     "## main.go\n\n**Usage**\nRun it.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeFileSections(tt.existing, tt.docs); got != tt.want {
+				t.Errorf("MergeFileSections() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package acode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/projectinfo"
+)
+
+var (
+	pathTemplateRegexp = regexp.MustCompile(`\{([^}/]+)\}`)
+	statusCodeRegexp   = regexp.MustCompile(`^([1-5][0-9][0-9]|[1-5]XX|default)$`)
+)
+
+// httpMethods are the operations that an OpenAPI path item can have
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// pathItemFields are the fields that an OpenAPI path item can have, in addition to the operations
+var pathItemFields = []string{"$ref", "summary", "description", "servers", "parameters"}
+
+// parameterLocations are the valid values for the "in" field of an OpenAPI parameter
+var parameterLocations = []string{"query", "header", "path", "cookie"}
+
+// parseSpec parses an OpenAPI specification in either JSON or YAML
+func parseSpec(text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		var spec interface{}
+		if err := json.Unmarshal([]byte(text), &spec); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		return spec, nil
+	}
+	docs, err := ParseYAML(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	if len(docs) != 1 {
+		return nil, fmt.Errorf("expected one YAML document, got %d", len(docs))
+	}
+	return docs[0], nil
+}
+
+// ValidateOpenAPI parses the given OpenAPI 3.1 specification, in JSON or YAML, and checks its structure:
+// the version, the info object, the paths and their operations, parameters and responses, the uniqueness
+// of the operation IDs and that all local $ref references can be resolved.
+// Returns a list of validation errors, which is empty if the specification is valid.
+func ValidateOpenAPI(text string) []error {
+	spec, err := parseSpec(text)
+	if err != nil {
+		return []error{err}
+	}
+	root, ok := spec.(map[string]interface{})
+	if !ok {
+		return []error{fmt.Errorf("the specification must be a mapping")}
+	}
+	var errs []error
+	if version, ok := root["openapi"].(string); !ok {
+		errs = append(errs, fmt.Errorf("openapi: the version is missing, it should be 3.1.0"))
+	} else if !strings.HasPrefix(version, "3.1") {
+		errs = append(errs, fmt.Errorf("openapi: the version is %q, it should be 3.1.0", version))
+	}
+	if info, ok := root["info"].(map[string]interface{}); !ok {
+		errs = append(errs, fmt.Errorf("info: is missing"))
+	} else {
+		for _, key := range []string{"title", "version"} {
+			if s, ok := info[key].(string); !ok || s == "" {
+				errs = append(errs, fmt.Errorf("info.%s: is missing", key))
+			}
+		}
+	}
+	paths, hasPaths := root["paths"].(map[string]interface{})
+	if !hasPaths && root["webhooks"] == nil && root["components"] == nil {
+		errs = append(errs, fmt.Errorf("paths: is missing"))
+	}
+	operationIDs := make(map[string]string)
+	for _, path := range sortedKeys(paths) {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("paths.%s: the path must start with /", path))
+		}
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("paths.%s: must be a mapping", path))
+			continue
+		}
+		errs = append(errs, validatePathItem(path, item, operationIDs)...)
+	}
+	for _, ref := range collectRefs(root) {
+		if !strings.HasPrefix(ref, "#/") {
+			continue // references to other files are not checked
+		}
+		if !resolveRef(root, ref) {
+			errs = append(errs, fmt.Errorf("$ref: %s can not be resolved", ref))
+		}
+	}
+	return errs
+}
+
+// validatePathItem checks the operations of a path item, and that all path template parameters are declared
+func validatePathItem(path string, item map[string]interface{}, operationIDs map[string]string) []error {
+	var errs []error
+	for _, key := range sortedKeys(item) {
+		if !hasString(httpMethods, key) && !hasString(pathItemFields, key) && !strings.HasPrefix(key, "x-") {
+			errs = append(errs, fmt.Errorf("paths.%s.%s: unknown field", path, key))
+		}
+	}
+	pathLevel, perrs := validateParameters("paths."+path, item["parameters"])
+	errs = append(errs, perrs...)
+	for _, method := range httpMethods {
+		raw, ok := item[method]
+		if !ok {
+			continue
+		}
+		where := "paths." + path + "." + method
+		op, ok := raw.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: must be a mapping", where))
+			continue
+		}
+		if id, ok := op["operationId"].(string); ok {
+			if previous, seen := operationIDs[id]; seen {
+				errs = append(errs, fmt.Errorf("%s: the operationId %q is already used by %s", where, id, previous))
+			} else {
+				operationIDs[id] = where
+			}
+		}
+		opLevel, perrs := validateParameters(where, op["parameters"])
+		errs = append(errs, perrs...)
+		for _, m := range pathTemplateRegexp.FindAllStringSubmatch(path, -1) {
+			if !pathLevel[m[1]] && !opLevel[m[1]] {
+				errs = append(errs, fmt.Errorf("%s: the path parameter %q is not declared", where, m[1]))
+			}
+		}
+		responses, ok := op["responses"].(map[string]interface{})
+		if !ok || len(responses) == 0 {
+			errs = append(errs, fmt.Errorf("%s.responses: is missing", where))
+			continue
+		}
+		for _, code := range sortedKeys(responses) {
+			if !statusCodeRegexp.MatchString(code) {
+				errs = append(errs, fmt.Errorf("%s.responses.%s: invalid status code", where, code))
+			}
+			response, ok := responses[code].(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s.responses.%s: must be a mapping", where, code))
+				continue
+			}
+			if _, isRef := response["$ref"]; !isRef {
+				if s, ok := response["description"].(string); !ok || s == "" {
+					errs = append(errs, fmt.Errorf("%s.responses.%s.description: is missing", where, code))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateParameters checks a list of parameters, and returns the names of the path parameters
+func validateParameters(where string, raw interface{}) (map[string]bool, []error) {
+	pathParameters := make(map[string]bool)
+	if raw == nil {
+		return pathParameters, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return pathParameters, []error{fmt.Errorf("%s.parameters: must be a list", where)}
+	}
+	var errs []error
+	for i, p := range list {
+		param, ok := p.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s.parameters[%d]: must be a mapping", where, i))
+			continue
+		}
+		if _, isRef := param["$ref"]; isRef {
+			continue
+		}
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		if name == "" {
+			errs = append(errs, fmt.Errorf("%s.parameters[%d].name: is missing", where, i))
+		}
+		if !hasString(parameterLocations, in) {
+			errs = append(errs, fmt.Errorf("%s.parameters[%d].in: must be one of %s", where, i, strings.Join(parameterLocations, ", ")))
+		}
+		if in == "path" {
+			pathParameters[name] = true
+			if required := param["required"]; required != true && required != "true" {
+				errs = append(errs, fmt.Errorf("%s.parameters[%d]: the path parameter %q must be required", where, i, name))
+			}
+		}
+		if param["schema"] == nil && param["content"] == nil {
+			errs = append(errs, fmt.Errorf("%s.parameters[%d]: %q needs either a schema or content", where, i, name))
+		}
+	}
+	return pathParameters, errs
+}
+
+// collectRefs returns all $ref values in the specification, sorted and without duplicates
+func collectRefs(node interface{}) []string {
+	seen := make(map[string]bool)
+	var walk func(interface{})
+	walk = func(node interface{}) {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if s, ok := value.(string); ok && key == "$ref" {
+					seen[s] = true
+					continue
+				}
+				walk(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(node)
+	refs := make([]string, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// resolveRef checks if a local JSON pointer reference, like "#/components/schemas/User", points to something in the specification
+func resolveRef(root map[string]interface{}, ref string) bool {
+	var node interface{} = root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if node, ok = m[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// specFormat returns "JSON" if the output file is a .json file, and "YAML" if not
+func (cfg *Config) specFormat() string {
+	if strings.HasSuffix(strings.ToLower(cfg.OutputFilename), ".json") {
+		return "JSON"
+	}
+	return "YAML"
+}
+
+// prepareOpenAPI warns if the project does not look like an API server
+func (cfg *Config) prepareOpenAPI(project *projectinfo.ProjectInfo) error {
+	if !project.APIServer && !cfg.Silent {
+		log.Println("Warning: the project does not look like an API server, the OpenAPI specification may be empty.")
+	}
+	return nil
+}
+
+// processCumulatively processes the chunks one by one, where each response is an updated version of the previous one.
+// This is used for generating a single file, like an OpenAPI specification, from several chunks. The existing output
+// file, if there is one, is used as the starting point.
+// Returns the final response and the approximate cost in USD. Returns an error if no chunk could be processed,
+// since the existing output file would otherwise be returned as if it had been generated.
+func (cfg *Config) processCumulatively(status io.Writer, project *projectinfo.ProjectInfo, jsonChunks []string, prompt string) ([]string, float64, error) {
+	var (
+		totalUSDCost float64
+		current      string
+		processed    int
+		lastErr      error
+		n            = len(jsonChunks)
+	)
+	if cfg.OutputFilename != "" && cfg.OutputFilename != "-" && files.IsFile(cfg.OutputFilename) {
+		if data, err := os.ReadFile(cfg.OutputFilename); err == nil {
+			current = string(data)
+		}
+	}
+	for i, chunk := range jsonChunks {
+		fmt.Fprintf(status, "Processing chunk %d of %d...\n", i+1, n)
+		if !cfg.Silent {
+			log.Printf("Processing chunk %d of %d....\n", i+1, n)
+		}
+		response, usdCost, err := cfg.processJournaledChunk(status, PhaseInitial, i, n, project, cfg.trimChunkPaths(chunk), prompt, current)
+		totalUSDCost += usdCost
+		if err != nil {
+			fmt.Fprintf(status, "Warning processing chunk %d/%d: %v\n", i+1, n, err)
+			if !cfg.Silent {
+				log.Printf("Warning processing chunk %d/%d: %v\n", i+1, n, err)
+			}
+			lastErr = err
+			continue
+		}
+		processed++
+		current = strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response)))
+	}
+	if processed == 0 && n > 0 {
+		return nil, totalUSDCost, fmt.Errorf("none of the %d chunk(s) could be processed: %v", n, lastErr)
+	}
+	if current == "" {
+		return nil, totalUSDCost, nil
+	}
+	return []string{current}, totalUSDCost, nil
+}
@@ -1,25 +1,142 @@
 package acode
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
-	"github.com/xyproto/ask"
 	"github.com/xyproto/files"
 )
 
+// formatResponse converts the response to the selected output format, and attaches fixes to the findings if possible.
+// Only findings can be formatted, so the output of other operations is returned as it is.
+func (cfg *Config) formatResponse(response, combinedFixResponses string) (string, error) {
+	if !reportsFindings(cfg.OpType) {
+		if format := strings.ToLower(cfg.OutputFormat); format != "" && format != "text" {
+			return "", fmt.Errorf("the %s output format can only be used with operations that report findings", cfg.OutputFormat)
+		}
+		return response, nil
+	}
+	formatter, err := FormatterFor(cfg.OutputFormat, cfg.OutputFilename)
+	if err != nil {
+		return "", err
+	}
+	if formatter == nil {
+		return response, nil
+	}
+	findings := AttachFixes(ParseFindings(cfg.OpType, response), combinedFixResponses)
+	var sb strings.Builder
+	if err := formatter.Format(&sb, findings, cfg.analyzedFiles); err != nil {
+		return "", fmt.Errorf("could not format the findings: %v", err)
+	}
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// updatesExistingFile checks if the output of the current operation is merged into, or compared with,
+// an existing output file, where the changes are shown as a diff before they are applied
+func (cfg *Config) updatesExistingFile() bool {
+	switch cfg.OpType {
+	case OpGenReadme:
+		return cfg.MergeReadme || cfg.watching
+	case OpGenDoc, OpGenAPI:
+		return cfg.watching // the documentation for the changed files is merged in, so the changes are shown first
+	case OpGenOpenAPI, OpGenDiagram:
+		return true
+	}
+	return false
+}
+
+// OutputResponse writes the response to the output file or to stdout, in the selected output format
 func (cfg *Config) OutputResponse(response string) error {
-	if cfg.OutputFilename == "-" || cfg.OutputFilename == "" {
+	return cfg.OutputResults(response, "")
+}
+
+// OutputResults writes the response to the output file or to stdout, in the selected output format.
+// The fix responses are used for including fix suggestions, for output formats that support them.
+func (cfg *Config) OutputResults(response, combinedFixResponses string) error {
+	// The cost estimate is a report, and not the output of the operation
+	if cfg.EstimateCost {
 		fmt.Println(response)
 		return nil
 	}
 
-	if !cfg.Force && files.Exists(cfg.OutputFilename) && !ask.YN(cfg.OutputFilename+" already exists. Overwrite it?") {
-		fmt.Println("Did nothing.")
+	response, err := cfg.formatResponse(response, combinedFixResponses)
+	if err != nil {
+		return err
+	}
+
+	if cfg.OpType == OpGenAnyFile && cfg.OutputFilename != "-" {
+		return cfg.outputGeneratedFiles(response)
+	}
+
+	if cfg.OpType == OpGenChangelog {
+		if strings.TrimSpace(cfg.commits) == "" || strings.HasPrefix(response, "No release notes") {
+			fmt.Println(response)
+			return nil
+		}
+		response = cfg.changelogSection(response)
+	}
+
+	if cfg.OpType == OpGenCommitMsg && !strings.HasPrefix(response, "No commit message") {
+		response = strings.TrimSuffix(FormatCommitMessage(response), "\n")
+	}
+
+	if cfg.OutputFilename == "-" || cfg.OutputFilename == "" {
+		fmt.Println(response)
 		return nil
 	}
 
-	if err := os.WriteFile(cfg.OutputFilename, []byte(response), 0644); err != nil {
+	if cfg.updatesExistingFile() && files.Exists(cfg.OutputFilename) {
+		existing, err := os.ReadFile(cfg.OutputFilename)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", cfg.OutputFilename, err)
+		}
+		switch cfg.OpType {
+		case OpGenReadme:
+			response = MergeMarkdown(string(existing), response)
+		case OpGenDiagram:
+			response = EmbedDiagram(string(existing), response)
+		default:
+			if !strings.HasSuffix(response, "\n") {
+				response += "\n"
+			}
+		}
+		diff := UnifiedDiff(cfg.OutputFilename, cfg.OutputFilename, string(existing), response)
+		if diff == "" {
+			if !cfg.Silent {
+				fmt.Println("No changes to", cfg.OutputFilename)
+			}
+			return nil
+		}
+		if !cfg.confirmDiff("Apply these changes to "+cfg.OutputFilename+"?", diff) {
+			return fmt.Errorf("did not update %s: %w", cfg.OutputFilename, ErrNotConfirmed)
+		}
+	} else if cfg.OpType == OpGenChangelog {
+		var existing []byte
+		if files.Exists(cfg.OutputFilename) {
+			if existing, err = os.ReadFile(cfg.OutputFilename); err != nil {
+				return fmt.Errorf("failed to read %s: %v", cfg.OutputFilename, err)
+			}
+		}
+		response = PrependChangelogSection(string(existing), response)
+		if len(existing) > 0 {
+			diff := UnifiedDiff(cfg.OutputFilename, cfg.OutputFilename, string(existing), response)
+			if diff == "" {
+				if !cfg.Silent {
+					fmt.Println("No changes to", cfg.OutputFilename)
+				}
+				return nil
+			}
+			if !cfg.confirmDiff("Apply these changes to "+cfg.OutputFilename+"?", diff) {
+				return fmt.Errorf("did not update %s: %w", cfg.OutputFilename, ErrNotConfirmed)
+			}
+		}
+	} else if files.Exists(cfg.OutputFilename) && !cfg.confirm(cfg.OutputFilename+" already exists. Overwrite it?", "") {
+		return fmt.Errorf("did not overwrite %s: %w", cfg.OutputFilename, ErrNotConfirmed)
+	}
+
+	if err := cfg.writeOutputFile(cfg.OutputFilename, []byte(response)); err != nil {
 		return fmt.Errorf("failed to write to output file %s: %v", cfg.OutputFilename, err)
 	}
 
@@ -28,3 +145,18 @@ func (cfg *Config) OutputResponse(response string) error {
 	}
 	return nil
 }
+
+// outputGeneratedFiles parses the files that were generated by OpGenAnyFile and writes them to cfg.Directory
+func (cfg *Config) outputGeneratedFiles(response string) error {
+	generated, err := ParseFileEnvelope(response)
+	if err != nil {
+		return err
+	}
+	written, errs := cfg.WriteGeneratedFiles(generated)
+	if !cfg.Silent {
+		for _, filename := range written {
+			fmt.Println("Output written successfully to", filename)
+		}
+	}
+	return errors.Join(errs...)
+}
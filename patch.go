@@ -0,0 +1,523 @@
+package acode
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/projectinfo"
+)
+
+// MaxFuzz is the maximum number of leading and trailing context lines that may be ignored when a hunk does not match exactly
+var MaxFuzz = 2
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Hunk is a single hunk from a unified diff. Each line is prefixed with ' ', '-' or '+'.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []string
+}
+
+// FilePatch holds all hunks for one file in a unified diff
+type FilePatch struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+// HunkRejection explains why a hunk (or an entire file patch, if Hunk is -1) could not be applied
+type HunkRejection struct {
+	Path   string
+	Hunk   int
+	Reason string
+}
+
+// PatchReport summarizes the result of applying patches
+type PatchReport struct {
+	Directory    string // the directory the patches were applied to, may be a scratch copy
+	Patched      []string
+	AppliedHunks int
+	Rejected     []HunkRejection
+}
+
+// Path returns the path of the file that the patch targets
+func (fp *FilePatch) Path() string {
+	if fp.NewPath == "" || fp.NewPath == "/dev/null" {
+		return fp.OldPath
+	}
+	return fp.NewPath
+}
+
+// IsNewFile returns true if the patch creates a new file
+func (fp *FilePatch) IsNewFile() bool {
+	return fp.OldPath == "/dev/null"
+}
+
+// oldAndNew returns the lines of the hunk before and after the change, without the prefixes
+func (h *Hunk) oldAndNew() ([]string, []string) {
+	var oldLines, newLines []string
+	for _, line := range h.Lines {
+		prefix, text := line[0], line[1:]
+		switch prefix {
+		case ' ':
+			oldLines = append(oldLines, text)
+			newLines = append(newLines, text)
+		case '-':
+			oldLines = append(oldLines, text)
+		case '+':
+			newLines = append(newLines, text)
+		}
+	}
+	return oldLines, newLines
+}
+
+// String returns the hunk in unified diff format
+func (h *Hunk) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	for _, line := range h.Lines {
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// trimDiffPath removes timestamps and the a/ or b/ prefix from a path in a "---" or "+++" line
+func trimDiffPath(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// ExtractPatches finds all unified diffs in the given AI response, which may also contain prose and code block markers
+func ExtractPatches(response string) []FilePatch {
+	var (
+		patches []FilePatch
+		lines   = strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+	)
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		fp := FilePatch{
+			OldPath: trimDiffPath(lines[i][4:]),
+			NewPath: trimDiffPath(lines[i+1][4:]),
+		}
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@") {
+			var hunk Hunk
+			hunk, i = parseHunk(lines, i)
+			if len(hunk.Lines) > 0 {
+				fp.Hunks = append(fp.Hunks, hunk)
+			}
+		}
+		if len(fp.Hunks) > 0 {
+			patches = append(patches, fp)
+		}
+		i-- // the loop increments i
+	}
+	return patches
+}
+
+// parseHunk parses the hunk that starts at lines[i] and returns it together with the index of the first line after it.
+// Hunk headers without line numbers are accepted, in which case the hunk ends at the first line that is not part of a diff.
+func parseHunk(lines []string, i int) (Hunk, int) {
+	var (
+		hunk        Hunk
+		counted     bool
+		oldN, newN  int
+		atoiDefault = func(s string, d int) int {
+			if n, err := strconv.Atoi(s); err == nil {
+				return n
+			}
+			return d
+		}
+	)
+	if m := hunkHeaderRegexp.FindStringSubmatch(lines[i]); m != nil {
+		hunk.OldStart = atoiDefault(m[1], 0)
+		hunk.OldLines = atoiDefault(m[2], 1)
+		hunk.NewStart = atoiDefault(m[3], 0)
+		hunk.NewLines = atoiDefault(m[4], 1)
+		counted = true
+	}
+loop:
+	for i++; i < len(lines); i++ {
+		if counted && oldN >= hunk.OldLines && newN >= hunk.NewLines {
+			break
+		}
+		line := lines[i]
+		if line == "" {
+			// Blank context lines are often stripped of their leading space
+			if !counted {
+				break
+			}
+			line = " "
+		}
+		switch line[0] {
+		case ' ':
+			oldN++
+			newN++
+		case '-':
+			if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+				break loop
+			}
+			oldN++
+		case '+':
+			newN++
+		case '\\': // "\ No newline at end of file"
+			continue
+		default:
+			break loop
+		}
+		hunk.Lines = append(hunk.Lines, line)
+	}
+	if !counted {
+		hunk.OldLines, hunk.NewLines = oldN, newN
+	}
+	return hunk, i
+}
+
+// relativeProjectPath returns the path of the given file, relative to cfg.Directory
+func (cfg *Config) relativeProjectPath(path string) string {
+	if rel, err := filepath.Rel(cfg.Directory, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filepath.Clean(path)
+}
+
+// resolvePatchPath finds the project file that the given patch path refers to, and returns the path relative to cfg.Directory.
+// Patch paths may be relative to cfg.Directory, include it, or only be a unique suffix of a project file path.
+func (cfg *Config) resolvePatchPath(project *projectinfo.ProjectInfo, patchPath string) (string, bool) {
+	patchPath = filepath.Clean(filepath.FromSlash(patchPath))
+	var suffixMatches []string
+	for _, file := range project.AllFiles() {
+		rel := cfg.relativeProjectPath(file.Path)
+		if rel == patchPath || filepath.Clean(file.Path) == patchPath {
+			return rel, true
+		}
+		if strings.HasSuffix(rel, psep+patchPath) {
+			suffixMatches = append(suffixMatches, rel)
+		}
+	}
+	if len(suffixMatches) == 1 {
+		return suffixMatches[0], true
+	}
+	return patchPath, false
+}
+
+// insideDirectory checks that the given relative path does not point outside of the directory it is relative to
+func insideDirectory(rel string) bool {
+	rel = filepath.Clean(rel)
+	return !filepath.IsAbs(rel) && rel != ".." && !strings.HasPrefix(rel, ".."+psep)
+}
+
+// ValidatePatches checks that every patch targets a file in the project (or creates a new file inside cfg.Directory).
+// The valid patches are returned with their paths made relative to cfg.Directory.
+func (cfg *Config) ValidatePatches(project *projectinfo.ProjectInfo, patches []FilePatch) ([]FilePatch, []HunkRejection) {
+	var (
+		valid    []FilePatch
+		rejected []HunkRejection
+	)
+	for _, fp := range patches {
+		if fp.NewPath == "/dev/null" {
+			rejected = append(rejected, HunkRejection{Path: fp.OldPath, Hunk: -1, Reason: "deleting files is not supported"})
+			continue
+		}
+		rel, found := cfg.resolvePatchPath(project, fp.Path())
+		switch {
+		case !insideDirectory(rel):
+			rejected = append(rejected, HunkRejection{Path: fp.Path(), Hunk: -1, Reason: "path is outside of " + cfg.Directory})
+			continue
+		case !found && !fp.IsNewFile():
+			rejected = append(rejected, HunkRejection{Path: fp.Path(), Hunk: -1, Reason: "no such file in the project"})
+			continue
+		case found && fp.IsNewFile():
+			rejected = append(rejected, HunkRejection{Path: fp.Path(), Hunk: -1, Reason: "patch creates a file that already exists"})
+			continue
+		}
+		if !fp.IsNewFile() {
+			fp.OldPath = rel
+		}
+		fp.NewPath = rel
+		valid = append(valid, fp)
+	}
+	return valid, rejected
+}
+
+// linesEqual compares two slices of lines, optionally ignoring differences in whitespace
+func linesEqual(a, b []string, ignoreWhitespace bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if ignoreWhitespace {
+			if strings.Join(strings.Fields(a[i]), " ") != strings.Join(strings.Fields(b[i]), " ") {
+				return false
+			}
+		} else if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// findLines searches for needle in haystack, starting at the expected position and moving outwards.
+// Returns -1 if the needle could not be found.
+func findLines(haystack, needle []string, expected int, ignoreWhitespace bool) int {
+	last := len(haystack) - len(needle)
+	if last < 0 {
+		return -1
+	}
+	if expected < 0 {
+		expected = 0
+	} else if expected > last {
+		expected = last
+	}
+	for distance := 0; distance <= last; distance++ {
+		for _, pos := range []int{expected - distance, expected + distance} {
+			if pos < 0 || pos > last || (distance == 0 && pos != expected) {
+				continue
+			}
+			if linesEqual(haystack[pos:pos+len(needle)], needle, ignoreWhitespace) {
+				return pos
+			}
+		}
+		if expected-distance < 0 && expected+distance > last {
+			break
+		}
+	}
+	return -1
+}
+
+// contextCounts returns the number of leading and trailing context lines in the hunk
+func (h *Hunk) contextCounts() (int, int) {
+	leading, trailing := 0, 0
+	for leading < len(h.Lines) && h.Lines[leading][0] == ' ' {
+		leading++
+	}
+	for trailing < len(h.Lines)-leading && h.Lines[len(h.Lines)-1-trailing][0] == ' ' {
+		trailing++
+	}
+	return leading, trailing
+}
+
+// matchHunk finds where the hunk applies in the given lines. It first looks for an exact match, then for a
+// match that ignores whitespace, and then drops up to MaxFuzz lines of leading and trailing context.
+// Returns the position, the number of lines to replace and the replacement lines.
+func matchHunk(lines []string, hunk Hunk, expected int) (int, int, []string, error) {
+	oldLines, newLines := hunk.oldAndNew()
+	if len(oldLines) == 0 {
+		// Pure insertion
+		pos := expected
+		if pos < 0 {
+			pos = 0
+		} else if pos > len(lines) {
+			pos = len(lines)
+		}
+		return pos, 0, newLines, nil
+	}
+	leading, trailing := hunk.contextCounts()
+	for fuzz := 0; fuzz <= MaxFuzz; fuzz++ {
+		skipStart, skipEnd := min(fuzz, leading), min(fuzz, trailing)
+		if skipStart+skipEnd >= len(oldLines) {
+			break
+		}
+		o := oldLines[skipStart : len(oldLines)-skipEnd]
+		n := newLines[skipStart : len(newLines)-skipEnd]
+		for _, ignoreWhitespace := range []bool{false, true} {
+			if pos := findLines(lines, o, expected+skipStart, ignoreWhitespace); pos >= 0 {
+				return pos, len(o), n, nil
+			}
+		}
+		if skipStart < fuzz && skipEnd < fuzz {
+			break // dropping more context is not possible
+		}
+	}
+	return 0, 0, nil, fmt.Errorf("the lines to be changed could not be found, even with a fuzz factor of %d", MaxFuzz)
+}
+
+// ApplyHunks applies the given hunks to the contents of a file, using fuzzy matching.
+// Returns the new contents, the number of applied hunks and a rejection for each hunk that did not apply.
+func ApplyHunks(path, contents string, hunks []Hunk) (string, int, []HunkRejection) {
+	var (
+		rejected      []HunkRejection
+		applied       int
+		offset        int
+		trailingNL    = contents == "" || strings.HasSuffix(contents, "\n")
+		lines         = strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+		previousStart = -1
+	)
+	if contents == "" {
+		lines = []string{}
+	}
+	for hunkIndex, hunk := range hunks {
+		expected := hunk.OldStart - 1 + offset
+		if oldLines, _ := hunk.oldAndNew(); len(oldLines) == 0 {
+			// For pure insertions, the old start is the line after which the new lines are inserted
+			expected = hunk.OldStart + offset
+		}
+		pos, count, replacement, err := matchHunk(lines, hunk, expected)
+		if err != nil {
+			rejected = append(rejected, HunkRejection{Path: path, Hunk: hunkIndex, Reason: err.Error()})
+			continue
+		}
+		if pos < previousStart {
+			rejected = append(rejected, HunkRejection{Path: path, Hunk: hunkIndex, Reason: "hunk matches before a previously applied hunk"})
+			continue
+		}
+		newLines := make([]string, 0, len(lines)-count+len(replacement))
+		newLines = append(newLines, lines[:pos]...)
+		newLines = append(newLines, replacement...)
+		newLines = append(newLines, lines[pos+count:]...)
+		lines = newLines
+		offset += len(replacement) - count
+		previousStart = pos + len(replacement)
+		applied++
+	}
+	result := strings.Join(lines, "\n")
+	if trailingNL && len(lines) > 0 {
+		result += "\n"
+	}
+	return result, applied, rejected
+}
+
+// CopyToScratch copies the given directory to a new temporary directory, skipping .git and .acode, and returns the path to the copy
+func CopyToScratch(dir string) (string, error) {
+	scratchDir, err := os.MkdirTemp("", "acode-")
+	if err != nil {
+		return "", fmt.Errorf("could not create a scratch directory: %v", err)
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == AcodeDirectory) {
+			return fs.SkipDir
+		}
+		target := filepath.Join(scratchDir, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+		return nil // skip symlinks, sockets and other special files
+	})
+	if err != nil {
+		os.RemoveAll(scratchDir)
+		return "", fmt.Errorf("could not copy %s to a scratch directory: %v", dir, err)
+	}
+	return scratchDir, nil
+}
+
+// applyFilePatches applies already validated patches to the files in the given directory
+func applyFilePatches(dir string, patches []FilePatch, report *PatchReport) error {
+	for _, fp := range patches {
+		filename := filepath.Join(dir, fp.Path())
+		var contents string
+		if !fp.IsNewFile() {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				report.Rejected = append(report.Rejected, HunkRejection{Path: fp.Path(), Hunk: -1, Reason: err.Error()})
+				continue
+			}
+			contents = string(data)
+		}
+		newContents, applied, rejected := ApplyHunks(fp.Path(), contents, fp.Hunks)
+		report.Rejected = append(report.Rejected, rejected...)
+		if applied == 0 {
+			continue
+		}
+		mode := os.FileMode(0644)
+		if fi, err := os.Stat(filename); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return fmt.Errorf("could not create directory for %s: %v", filename, err)
+		}
+		if err := writeFileAtomic(filename, []byte(newContents), mode); err != nil {
+			return fmt.Errorf("could not write %s: %v", filename, err)
+		}
+		report.Patched = append(report.Patched, fp.Path())
+		report.AppliedHunks += applied
+	}
+	return nil
+}
+
+// ApplyFixes extracts the unified diffs from the combined fix responses, validates them against the project and applies them.
+// If inPlace is false, the patches are applied to a scratch copy of cfg.Directory, which is given in the returned report.
+// If inPlace is true, the files in cfg.Directory are modified, if cfg.Force is set or if cfg.Confirmer confirms it.
+func (cfg *Config) ApplyFixes(status io.Writer, project *projectinfo.ProjectInfo, combinedFixResponses string, inPlace bool) (*PatchReport, error) {
+	report := &PatchReport{}
+
+	patches, rejected := cfg.ValidatePatches(project, ExtractPatches(combinedFixResponses))
+	report.Rejected = append(report.Rejected, rejected...)
+	if len(patches) == 0 {
+		fmt.Fprintln(status, "No applicable patches found.")
+		return report, nil
+	}
+
+	if inPlace {
+		var details strings.Builder
+		for _, fp := range patches {
+			fmt.Fprintf(&details, "--- %s\n+++ %s\n", fp.Path(), fp.Path())
+			for _, hunk := range fp.Hunks {
+				details.WriteString(hunk.String())
+			}
+		}
+		if !cfg.confirm(fmt.Sprintf("Apply %d patch(es) to the files in %s?", len(patches), cfg.Directory), details.String()) {
+			return report, fmt.Errorf("did not apply the patches: %w", ErrNotConfirmed)
+		}
+		report.Directory = cfg.Directory
+	} else {
+		scratchDir, err := CopyToScratch(cfg.Directory)
+		if err != nil {
+			return nil, err
+		}
+		report.Directory = scratchDir
+	}
+
+	if err := applyFilePatches(report.Directory, patches, report); err != nil {
+		return report, err
+	}
+
+	fmt.Fprintf(status, "Applied %d hunk(s) to %d file(s) in %s.\n", report.AppliedHunks, len(report.Patched), files.ShortPath(report.Directory))
+	for _, r := range report.Rejected {
+		if r.Hunk < 0 {
+			fmt.Fprintf(status, "Rejected patch for %s: %s\n", r.Path, r.Reason)
+		} else {
+			fmt.Fprintf(status, "Rejected hunk #%d for %s: %s\n", r.Hunk+1, r.Path, r.Reason)
+		}
+	}
+	if !cfg.Silent {
+		log.Printf("Applied %d hunk(s), rejected %d.\n", report.AppliedHunks, len(report.Rejected))
+	}
+	return report, nil
+}
@@ -0,0 +1,147 @@
+package acode
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xyproto/projectinfo"
+)
+
+func TestExtractPatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []FilePatch
+	}{
+		{
+			name:     "no diff",
+			response: "No fixes needed.",
+			want:     nil,
+		},
+		{
+			name:     "diff in a code block with prose",
+			response: "Here is the fix:\n```diff\n--- a/main.go\n+++ b/main.go\n@@ -1,3 +1,3 @@\n package main\n-var x = 1\n+var x = 2\n \n```\nThat should do it.",
+			want: []FilePatch{{
+				OldPath: "main.go",
+				NewPath: "main.go",
+				Hunks:   []Hunk{{OldStart: 1, OldLines: 3, NewStart: 1, NewLines: 3, Lines: []string{" package main", "-var x = 1", "+var x = 2", " "}}},
+			}},
+		},
+		{
+			name:     "new file and timestamps",
+			response: "--- /dev/null\t2024-01-01\n+++ b/new.go\t2024-01-01\n@@ -0,0 +1,2 @@\n+package main\n+\n",
+			want: []FilePatch{{
+				OldPath: "/dev/null",
+				NewPath: "new.go",
+				Hunks:   []Hunk{{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 2, Lines: []string{"+package main", "+"}}},
+			}},
+		},
+		{
+			name:     "two files, and a hunk header without line numbers",
+			response: "--- a/a.go\n+++ b/a.go\n@@ -2 +2 @@\n-a\n+b\n--- a/b.go\n+++ b/b.go\n@@\n-c\n+d\nprose\n",
+			want: []FilePatch{
+				{OldPath: "a.go", NewPath: "a.go", Hunks: []Hunk{{OldStart: 2, OldLines: 1, NewStart: 2, NewLines: 1, Lines: []string{"-a", "+b"}}}},
+				{OldPath: "b.go", NewPath: "b.go", Hunks: []Hunk{{OldLines: 1, NewLines: 1, Lines: []string{"-c", "+d"}}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractPatches(tt.response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractPatches() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	const contents = "line 1\nline 2\nline 3\nline 4\nline 5\n"
+	tests := []struct {
+		name        string
+		hunk        Hunk
+		want        string
+		wantApplied int
+	}{
+		{
+			name:        "exact match",
+			hunk:        Hunk{OldStart: 2, Lines: []string{" line 2", "-line 3", "+line three", " line 4"}},
+			want:        "line 1\nline 2\nline three\nline 4\nline 5\n",
+			wantApplied: 1,
+		},
+		{
+			name:        "wrong line number",
+			hunk:        Hunk{OldStart: 40, Lines: []string{" line 4", "-line 5", "+line five"}},
+			want:        "line 1\nline 2\nline 3\nline 4\nline five\n",
+			wantApplied: 1,
+		},
+		{
+			name:        "whitespace differences",
+			hunk:        Hunk{OldStart: 1, Lines: []string{"-line   1", "+first line"}},
+			want:        "first line\nline 2\nline 3\nline 4\nline 5\n",
+			wantApplied: 1,
+		},
+		{
+			name:        "wrong leading context is fuzzed away",
+			hunk:        Hunk{OldStart: 3, Lines: []string{" not in the file", "-line 3", "+line three"}},
+			want:        "line 1\nline 2\nline three\nline 4\nline 5\n",
+			wantApplied: 1,
+		},
+		{
+			name:        "pure insertion",
+			hunk:        Hunk{OldStart: 1, Lines: []string{"+inserted"}},
+			want:        "line 1\ninserted\nline 2\nline 3\nline 4\nline 5\n",
+			wantApplied: 1,
+		},
+		{
+			name:        "lines that are not in the file",
+			hunk:        Hunk{OldStart: 1, Lines: []string{"-line 6", "+line six"}},
+			want:        contents,
+			wantApplied: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, applied, rejected := ApplyHunks("file.txt", contents, []Hunk{tt.hunk})
+			if got != tt.want || applied != tt.wantApplied {
+				t.Errorf("ApplyHunks() = %q, %d, want %q, %d", got, applied, tt.want, tt.wantApplied)
+			}
+			if len(rejected) != 1-tt.wantApplied {
+				t.Errorf("ApplyHunks() rejected %d hunk(s), want %d", len(rejected), 1-tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestValidatePatches(t *testing.T) {
+	cfg := &Config{Directory: "/project"}
+	project := &projectinfo.ProjectInfo{SourceFiles: []projectinfo.FileInfo{{Path: "/project/pkg/a.go"}}}
+	hunk := []Hunk{{Lines: []string{"-a", "+b"}}}
+	tests := []struct {
+		name   string
+		patch  FilePatch
+		valid  string // the path of the valid patch, or blank if it is rejected
+		reason string
+	}{
+		{"existing file", FilePatch{OldPath: "pkg/a.go", NewPath: "pkg/a.go", Hunks: hunk}, "pkg/a.go", ""},
+		{"unique suffix", FilePatch{OldPath: "a.go", NewPath: "a.go", Hunks: hunk}, "pkg/a.go", ""},
+		{"new file", FilePatch{OldPath: "/dev/null", NewPath: "pkg/b.go", Hunks: hunk}, "pkg/b.go", ""},
+		{"deleted file", FilePatch{OldPath: "pkg/a.go", NewPath: "/dev/null", Hunks: hunk}, "", "deleting files is not supported"},
+		{"missing file", FilePatch{OldPath: "c.go", NewPath: "c.go", Hunks: hunk}, "", "no such file in the project"},
+		{"outside of the project", FilePatch{OldPath: "../x.go", NewPath: "../x.go", Hunks: hunk}, "", "path is outside of /project"},
+		{"new file that exists", FilePatch{OldPath: "/dev/null", NewPath: "pkg/a.go", Hunks: hunk}, "", "patch creates a file that already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, rejected := cfg.ValidatePatches(project, []FilePatch{tt.patch})
+			if tt.valid != "" {
+				if len(valid) != 1 || valid[0].Path() != tt.valid {
+					t.Errorf("ValidatePatches() = %v, %v, want a valid patch for %s", valid, rejected, tt.valid)
+				}
+				return
+			}
+			if len(valid) != 0 || len(rejected) != 1 || rejected[0].Reason != tt.reason {
+				t.Errorf("ValidatePatches() = %v, %v, want the rejection %q", valid, rejected, tt.reason)
+			}
+		})
+	}
+}
@@ -106,6 +106,11 @@ func (cfg *Config) PostPrompt(prompt string) (string, error) {
 // If there are errors, a warning is logged and the tokens are estimated instead.
 func (cfg *Config) CountPromptTokens(prompt string) int {
 
+	// Cost estimation should not send anything
+	if cfg.EstimateCost {
+		return projectinfo.CountTokens(prompt)
+	}
+
 	PostURL := strings.Replace(cfg.Model.PostURL, "/query", "/counttext", 1)
 
 	var (
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/xyproto/projectinfo"
 )
@@ -20,18 +21,33 @@ func FileContents(project *projectinfo.ProjectInfo, filename string) string {
 	return projectinfo.FindFileName(project.ConfAndDocFiles, filename).Contents
 }
 
-// ProcessChunk processes a chunk of source code with either the initial or the correction prompt (if not blank)
-func (cfg *Config) ProcessChunk(status io.Writer, i, n int, project *projectinfo.ProjectInfo, jsonChunk, promptTemplate, previousAIAnswer string) (string, float64, error) {
-	promptData := TemplateData{
+// templateData returns the data for the prompt templates, for the given chunk and previous answer
+func (cfg *Config) templateData(project *projectinfo.ProjectInfo, jsonChunk, previousAIAnswer string) TemplateData {
+	return TemplateData{
 		ReadmeContents:   "\n\n" + FileContents(project, "README.md") + "\n",
 		SourceCode:       "\n\n" + jsonChunk + "\n",
 		PreviousAIAnswer: "\n\n" + previousAIAnswer + "\n",
+		TargetFiles:      cfg.targetFilesList(),
+		Diff:             "\n\n" + cfg.diff + "\n",
+		Commits:          "\n" + cfg.commits + "\n",
+		Version:          cfg.releaseVersion,
+		Convention:       commitConventions[cfg.commitConvention()],
+		Checklist:        cfg.checklist,
+		SpecFormat:       cfg.specFormat(),
 	}
-	prompt, err := cfg.BuildPrompt(promptTemplate, promptData)
+}
+
+// ProcessChunk processes a chunk of source code with either the initial or the correction prompt (if not blank)
+func (cfg *Config) ProcessChunk(status io.Writer, i, n int, project *projectinfo.ProjectInfo, jsonChunk, promptTemplate, previousAIAnswer string) (string, float64, error) {
+	prompt, err := cfg.BuildPrompt(promptTemplate, cfg.templateData(project, jsonChunk, previousAIAnswer))
 	if err != nil {
 		return "", 0, err
 	}
+	return cfg.processPrompt(status, i, n, prompt)
+}
 
+// processPrompt sends the prompt that was built for chunk i of n, and warns if it exceeds the token limit
+func (cfg *Config) processPrompt(status io.Writer, i, n int, prompt string) (string, float64, error) {
 	// Calculating token count and cost
 	sentTokenCount := cfg.CountPromptTokens(prompt)
 	if sentTokenCount > cfg.Model.MaxTokens {
@@ -42,6 +58,28 @@ func (cfg *Config) ProcessChunk(status io.Writer, i, n int, project *projectinfo
 		//return "", 0, fmt.Errorf("prompt exceeds the token limit of %d tokens, got %d tokens", cfg.Model.MaxTokens, sentTokenCount)
 	}
 
+	label := ""
+	if n != 1 {
+		label = fmt.Sprintf("[source code chunk %d/%d] ", i+1, n)
+	}
+	return cfg.postAndReport(status, label, prompt, sentTokenCount)
+}
+
+// postAndReport sends the prompt, retries with the fallback model if that fails, and reports the approximate cost to status.
+// The label is used as a prefix for the cost report.
+// Responses are cached by prompt, and cache hits are reported as zero cost.
+func (cfg *Config) postAndReport(status io.Writer, label, prompt string, sentTokenCount int) (string, float64, error) {
+	if entry, ok := cfg.cachedResponse(prompt); ok {
+		receivedTokenCount := cfg.CountPromptTokens(entry.Response)
+		fmt.Fprintf(status, "%sApproximate cost: $0.00 for %d sent and %d received tokens (cached response from %s).\n", label, sentTokenCount, receivedTokenCount, entry.Created.Format("2006-01-02 15:04"))
+		if !cfg.Silent {
+			log.Printf("Using a cached response from %s, given by the %s model\n", entry.Created.Format(time.RFC3339), entry.Model)
+		}
+		cfg.lastModelName = entry.Model
+		return entry.Response, 0, nil
+	}
+
+	modelName := cfg.Model.Name
 	result, err := cfg.PostPrompt(prompt)
 	if err != nil {
 		// Try again, using the fallback model
@@ -54,47 +92,53 @@ func (cfg *Config) ProcessChunk(status io.Writer, i, n int, project *projectinfo
 		}
 
 		result, err = cfg.PostPrompt(prompt)
+		modelName = cfg.Model.Name
 
 		cfg.Model = tmp
 	}
 
+	if err == nil {
+		cfg.storeResponse(prompt, modelName, result)
+	}
+	cfg.lastModelName = modelName
+
 	receivedTokenCount := cfg.CountPromptTokens(result)
 
 	usdCost := cfg.Model.CalculateCost(sentTokenCount, receivedTokenCount)
 
-	if n == 1 {
-		fmt.Fprintf(status, "Approximate cost: $%.2f for %d sent and %d received tokens.\n", usdCost, sentTokenCount, receivedTokenCount)
-	} else {
-		fmt.Fprintf(status, "[source code chunk %d/%d] Approximate cost: $%.2f for %d sent and %d received tokens.\n", i+1, n, usdCost, sentTokenCount, receivedTokenCount)
-	}
+	fmt.Fprintf(status, "%sApproximate cost: $%.2f for %d sent and %d received tokens.\n", label, usdCost, sentTokenCount, receivedTokenCount)
 
 	return result, usdCost, err
 }
 
+// trimChunkPaths replaces the (potentially cryptic temp directory) in the JSON chunk with a blank string,
+// but only for a minimum amount of path separators.
+func (cfg *Config) trimChunkPaths(chunk string) string {
+	if strings.Count(cfg.Directory, psep) > 2 {
+		if !strings.HasSuffix(cfg.Directory, psep) {
+			cfg.Directory += psep
+		}
+		chunk = strings.ReplaceAll(chunk, cfg.Directory, "")
+	}
+	return chunk
+}
+
 // processWithPrompt processes the source code JSON chunks with a given prompt and an optional previousAIAnswer string (can be empty)
-// it returns a slice of answers and an approximate cost in USD
-func (cfg *Config) processWithPrompt(status io.Writer, project *projectinfo.ProjectInfo, jsonChunks []string, prompt, previousAIAnswer string) ([]string, float64) {
+// in the given phase. It returns a slice of answers and an approximate cost in USD.
+func (cfg *Config) processWithPrompt(status io.Writer, phase string, project *projectinfo.ProjectInfo, jsonChunks []string, prompt, previousAIAnswer string) ([]string, float64) {
 	var (
 		totalUSDCost float64
 		responses    []string
 		n            = len(jsonChunks)
 	)
 	for i := 0; i < n; i++ {
-		chunk := jsonChunks[i]
-		// Replace the (potentially cryptic temp directory) in the JSON chunk with a blank string
-		// but only for a minimum amount of path separators.
-		if strings.Count(cfg.Directory, psep) > 2 {
-			if !strings.HasSuffix(cfg.Directory, psep) {
-				cfg.Directory += psep
-			}
-			chunk = strings.ReplaceAll(chunk, cfg.Directory, "")
-		}
+		chunk := cfg.trimChunkPaths(jsonChunks[i])
 		fmt.Fprintf(status, "Processing chunk %d of %d...\n", i+1, n)
 		if !cfg.Silent {
 			log.Printf("Processing chunk %d of %d....\n", i+1, n)
 		}
 		// First process the initial prompt, and with no previous answer
-		initialResponse, usdCost, err := cfg.ProcessChunk(status, i, n, project, chunk, prompt, previousAIAnswer)
+		initialResponse, usdCost, err := cfg.processJournaledChunk(status, phase, i, n, project, chunk, prompt, previousAIAnswer)
 		if err != nil {
 			fmt.Fprintf(status, "Warning processing chunk %d/%d: %v\n", i+1, n, err)
 			if !cfg.Silent {
@@ -116,56 +160,163 @@ func (cfg *Config) Process(status io.Writer, project *projectinfo.ProjectInfo) (
 		usdCost, totalUSDCost float64
 		err                   error
 	)
+	defer cfg.pruneCacheIfWritten()
 
 	fmt.Fprintf(status, "Processing project: %s\n", project.Name)
 	if !cfg.Silent {
 		log.Printf("Processing project: %s\n", project.Name)
 	}
+	cfg.reportSkippedFiles(status)
 
-	// First create the prompt without the JSON chunk, then count the tokens and extract that from the MaxToken when chunking
+	switch cfg.OpType {
+	case OpGenDiagram, OpChat, OpGenGodoc, OpGenTest:
+		if cfg.EstimateCost {
+			return "", "", 0, 0, fmt.Errorf("cost estimation is not supported for the selected operation")
+		}
+	}
+	// Retrieval updates the search index and embeds the changed files, which is not free and writes to the project
+	if cfg.EstimateCost && cfg.RetrievalQuery != "" {
+		return "", "", 0, 0, fmt.Errorf("cost estimation is not supported together with a retrieval query, since the files would have to be indexed and embedded")
+	}
 
-	initialPromptData := TemplateData{
-		ReadmeContents:   "\n\n" + FileContents(project, "README.md") + "\n",
-		SourceCode:       "\n\n\n",
-		PreviousAIAnswer: "\n\n\n",
+	// Diagrams are generated from the import graph, instead of from the source code
+	if cfg.OpType == OpGenDiagram {
+		section, usdCost, err := cfg.GenerateDiagram(status, project)
+		if section == "" {
+			return "No diagram generated.", "", 0, usdCost, err
+		}
+		return section, "", 0, usdCost, err
 	}
-	promptWithoutSourceCode, err := cfg.BuildPrompt(cfg.InitialPrompt, initialPromptData)
+
+	// The chat is interactive, and the library never reads from the terminal on its own
+	if cfg.OpType == OpChat {
+		return "", "", 0, 0, fmt.Errorf("the chat is interactive: use NewChat and then Chat.Run or Chat.Ask with your own reader and writer")
+	}
+
+	// Doc comments are generated per source file and inserted into it, instead of processing the project in chunks
+	if cfg.OpType == OpGenGodoc {
+		output, usdCost, err := cfg.GenerateGodoc(status, project)
+		return output, "", 0, usdCost, err
+	}
+
+	// Tests are generated per source file and written next to it, instead of processing the project in chunks
+	if cfg.OpType == OpGenTest {
+		written, usdCost, err := cfg.GenerateTests(status, project)
+		if len(written) == 0 {
+			return "No tests generated.", "", 0, usdCost, err
+		}
+		return "Generated tests:\n" + strings.Join(written, "\n"), "", 0, usdCost, err
+	}
+
+	// First create the prompt without the JSON chunk, then count the tokens and extract that from the MaxToken when chunking
+
+	promptWithoutSourceCode, err := cfg.BuildPrompt(cfg.InitialPrompt, cfg.templateData(project, "", ""))
 	if err != nil {
 		return "", "", 0, 0, err
 	}
 	barePromptTokenCount := cfg.CountPromptTokens(promptWithoutSourceCode)
 
-	// TODO: Let project.Chunk take an extra barePromptTokenCount int
-	cfg.Model.MaxTokens -= int(float64(barePromptTokenCount) * PromptMargin)
-	jsonChunks, err = Chunk(cfg, project, !cfg.ExcludeSources, cfg.IncludeConfAndDoc)
-	if err != nil {
-		cfg.Model.MaxTokens += barePromptTokenCount
-		return "", "", 0, 0, err
+	cfg.analyzedFiles = nil
+	if cfg.RetrievalQuery != "" {
+		// Only send the file segments that are the most relevant for the query, instead of all the files
+		jsonChunks, err = cfg.retrievalChunks(status, project, barePromptTokenCount)
+		if err != nil {
+			return "", "", 0, 0, err
+		}
+	} else {
+		// TODO: Let project.Chunk take an extra barePromptTokenCount int
+		maxTokens := cfg.Model.MaxTokens
+		cfg.Model.MaxTokens -= int(float64(barePromptTokenCount) * PromptMargin)
+		jsonChunks, err = Chunk(cfg, project, !cfg.ExcludeSources, cfg.IncludeConfAndDoc)
+		cfg.Model.MaxTokens = maxTokens
+		if err != nil {
+			return "", "", 0, 0, err
+		}
+
+		if !cfg.ExcludeSources {
+			for _, file := range project.SourceFiles {
+				cfg.analyzedFiles = append(cfg.analyzedFiles, cfg.relativeProjectPath(file.Path))
+			}
+		}
+		if cfg.IncludeConfAndDoc {
+			for _, file := range project.ConfAndDocFiles {
+				cfg.analyzedFiles = append(cfg.analyzedFiles, cfg.relativeProjectPath(file.Path))
+			}
+		}
+	}
+
+	// Some operations can be carried out even if there are no project files to send along
+	if len(jsonChunks) == 0 && sourcesAreOptional(cfg.OpType) {
+		jsonChunks = []string{"[]"}
 	}
-	cfg.Model.MaxTokens += barePromptTokenCount
 
 	fmt.Fprintf(status, "Project chunked into %d chunks.\n", len(jsonChunks))
 	if !cfg.Silent {
 		log.Printf("Project chunked into %d chunks.\n", len(jsonChunks))
 	}
 
+	// Only count the tokens and estimate the cost, if that is all that is wanted
+	if cfg.EstimateCost {
+		estimate, err := cfg.estimateCost(project, jsonChunks)
+		if err != nil {
+			return "", "", 0, 0, err
+		}
+		models := AllModels
+		if len(models) == 0 {
+			models = []Model{cfg.Model}
+		}
+		return estimate.Format(models), "", 0, 0, nil
+	}
+
+	// If cfg.Journal or cfg.Resume is set, record the processed chunks, so that the run can be resumed if it is interrupted
+	if err := cfg.openJournal(status); err != nil {
+		return "", "", 0, 0, err
+	}
+	cfg.pendingResponses = nil
+
 	fmt.Fprintln(status, "Using the initial prompt...")
 	if !cfg.Silent {
 		log.Println("Using the initial prompt...")
 	}
 
 	// Process the chunks with the initial prompt, and prepare to return combinedInitialResponses
-	responses, usdCost = cfg.processWithPrompt(status, project, jsonChunks, cfg.InitialPrompt, "")
+	if cfg.OpType == OpGenOpenAPI {
+		// Each chunk adds to the specification from the previous chunks
+		responses, usdCost, err = cfg.processCumulatively(status, project, jsonChunks, cfg.InitialPrompt)
+		if err != nil {
+			return "", "", 0, totalUSDCost + usdCost, err
+		}
+	} else {
+		responses, usdCost = cfg.processWithPrompt(status, PhaseInitial, project, jsonChunks, cfg.InitialPrompt, "")
+	}
 	totalUSDCost += usdCost
 	combinedInitialResponses := ""
+	separator := "\n"
+	if cfg.OpType == OpGenCatalog {
+		separator = "\n---\n" // one YAML document per chunk
+	}
 	for _, response := range responses {
 		if strings.HasPrefix(response, "No") && strings.HasSuffix(response, "found.") {
 			continue
 		}
-		combinedInitialResponses += "\n" + response
+		if combinedInitialResponses != "" {
+			combinedInitialResponses += separator
+		}
+		combinedInitialResponses += response
 	}
 	combinedInitialResponses = strings.TrimSpace(combinedInitialResponses)
 
+	if validator, ok := validators[cfg.OpType]; ok && combinedInitialResponses != "" {
+		combinedInitialResponses, usdCost, err = cfg.validateAndRetry(status, validator, combinedInitialResponses)
+		totalUSDCost += usdCost
+		if err != nil {
+			cfg.pendingResponses = nil // the responses that make up an invalid file are not cached
+			return "", "", 0, totalUSDCost, err
+		}
+	}
+	// The generated file is valid, so the responses it was made from can be cached
+	cfg.storePendingResponses()
+
 	nothingFound := combinedInitialResponses == "" || (strings.HasPrefix(combinedInitialResponses, "No ") && strings.Count(combinedInitialResponses, " ") < 5)
 
 	var (
@@ -180,7 +331,7 @@ func (cfg *Config) Process(status io.Writer, project *projectinfo.ProjectInfo) (
 		}
 
 		// Process the chunks with the fix prompt, and prepare to return combinedFixResponses
-		responses, usdCost = cfg.processWithPrompt(status, project, jsonChunks, cfg.FixPrompt, combinedInitialResponses)
+		responses, usdCost = cfg.processWithPrompt(status, PhaseFix, project, jsonChunks, cfg.FixPrompt, combinedInitialResponses)
 		totalUSDCost += usdCost
 		for _, response := range responses {
 			if strings.HasPrefix(response, "No ") && (strings.HasSuffix(response, " found.") || strings.HasSuffix(response, " needed.")) {
@@ -195,7 +346,7 @@ func (cfg *Config) Process(status io.Writer, project *projectinfo.ProjectInfo) (
 		}
 
 		// Process the chunks with the confidence prompt, and prepare to return combinedConfidenceResponses
-		responses, usdCost = cfg.processWithPrompt(status, project, jsonChunks, cfg.ConfidencePrompt, combinedInitialResponses)
+		responses, usdCost = cfg.processWithPrompt(status, PhaseConfidence, project, jsonChunks, cfg.ConfidencePrompt, combinedInitialResponses)
 		totalUSDCost += usdCost
 		var found bool
 		var confidenceFloat float64 = 5.0 // from 1 to 10, will be converted to an int before it is returned
@@ -210,7 +361,25 @@ func (cfg *Config) Process(status io.Writer, project *projectinfo.ProjectInfo) (
 			}
 		}
 		confidence = int(confidenceFloat)
+
+		if cfg.VerifyFixes && strings.TrimSpace(combinedFixResponses) != "" {
+			fmt.Fprintln(status, "Verifying the fixes by building and testing a scratch copy...")
+			if !cfg.Silent {
+				log.Println("Verifying the fixes by building and testing a scratch copy...")
+			}
+			verifications, err := cfg.VerifyPatches(status, project, combinedFixResponses)
+			if err != nil {
+				fmt.Fprintf(status, "Warning: could not verify the fixes: %v\n", err)
+				if !cfg.Silent {
+					log.Printf("Warning: could not verify the fixes: %v\n", err)
+				}
+			} else {
+				confidence = adjustConfidence(confidence, verifications)
+			}
+		}
 	}
+	// The fix and confidence responses do not depend on the generated file being valid
+	cfg.storePendingResponses()
 
 	combinedInitialResponses = strings.TrimSpace(combinedInitialResponses)
 	if combinedInitialResponses == "" {
@@ -227,6 +396,16 @@ func (cfg *Config) Process(status io.Writer, project *projectinfo.ProjectInfo) (
 			combinedInitialResponses = "No bugs found."
 		case OpFindTypo:
 			combinedInitialResponses = "No typos found."
+		case OpReview:
+			combinedInitialResponses = "No issues found."
+		case OpSecurityAudit:
+			combinedInitialResponses = "No vulnerabilities found."
+		case OpGenOpenAPI:
+			combinedInitialResponses = "No specification generated."
+		case OpGenChangelog:
+			combinedInitialResponses = "No release notes generated."
+		case OpGenCommitMsg:
+			combinedInitialResponses = "No commit message generated."
 		case OpGenDoc:
 			fallthrough
 		default:
@@ -234,5 +413,7 @@ func (cfg *Config) Process(status io.Writer, project *projectinfo.ProjectInfo) (
 		}
 	}
 
+	cfg.finishJournal()
+
 	return combinedInitialResponses, combinedFixResponses, confidence, totalUSDCost, nil
 }
@@ -5,19 +5,41 @@ type TemplateData struct {
 	ReadmeContents   string
 	SourceCode       string
 	PreviousAIAnswer string
+	ValidationErrors string
+	TargetFiles      string
+	TestExamples     string
+	Diff             string
+	Commits          string
+	Version          string
+	Convention       string
+	Checklist        string
+	SpecFormat       string
+	Graph            string
+	Declarations     string
+	History          string
+	Question         string
 }
 
 type OperationType int
 
 // The different operations that this program can do
 const (
-	OpGenDoc     = iota // generate general documentation
-	OpGenAPI            // generate API documentation
-	OpGenReadme         // generate a README.md style file
-	OpGenCatalog        // generate app-catalog.yaml config for Backstage
-	OpGenAnyFile        // generate any file
-	OpFindBug           // find a bug
-	OpFindTypo          // find a typo
+	OpGenDoc        = iota // generate general documentation
+	OpGenAPI               // generate API documentation
+	OpGenReadme            // generate a README.md style file
+	OpGenCatalog           // generate app-catalog.yaml config for Backstage
+	OpGenAnyFile           // generate any file
+	OpFindBug              // find a bug
+	OpFindTypo             // find a typo
+	OpGenTest              // generate unit tests for uncovered code
+	OpReview               // review the changes since a git ref
+	OpGenChangelog         // generate release notes for CHANGELOG.md from the git log
+	OpGenCommitMsg         // generate a commit message for the staged changes
+	OpSecurityAudit        // audit the code for security vulnerabilities
+	OpGenOpenAPI           // generate an OpenAPI specification for an API server, only if selected explicitly
+	OpGenDiagram           // generate an architecture diagram from the import graph
+	OpGenGodoc             // generate doc comments for exported Go identifiers
+	OpChat                 // answer questions about the project, interactively
 )
 
 func GetDefaultFilename(opType OperationType) string {
@@ -29,9 +51,19 @@ func GetDefaultFilename(opType OperationType) string {
 	case OpGenCatalog:
 		return "app-catalog.yaml"
 	case OpGenAnyFile:
-		return "unspecified.filename"
-	case OpFindBug, OpFindTypo:
+		return "" // the files to write are given by Config.TargetFiles
+	case OpFindBug, OpFindTypo, OpReview, OpGenCommitMsg, OpSecurityAudit:
 		return "-"
+	case OpChat:
+		return "-" // the answers are written as the conversation goes
+	case OpGenGodoc:
+		return "-" // the doc comments are inserted into the source files, only a summary or diff is output
+	case OpGenChangelog:
+		return "CHANGELOG.md"
+	case OpGenOpenAPI:
+		return "openapi.yaml"
+	case OpGenTest:
+		return "-" // the tests are written next to the source files, only a summary is output
 	case OpGenDoc:
 		fallthrough
 	default:
@@ -63,13 +95,55 @@ Assume a basic understanding of software projects but unfamiliarity with this sp
 3. metadata: Extract the project's name, title, and description from available documentation. Include repository annotations if the URL is available.
 4. spec: Categorize the component type, lifecycle status, and ownership information using existing documentation.
 Do not make assumptions or introduce inaccuracies.
+{{.SourceCode}}`
+	case OpGenAnyFile:
+		return `Generate the following files for this project, based on the project source code and documentation: {{.TargetFiles}}Use the paths exactly as given. Return all files in this JSON format, and nothing else: {"files": [{"path": "path/to/file", "contents": "the complete file contents"}]}
 {{.SourceCode}}`
 	case OpFindBug:
-		return `Review the following code and identify any bugs. If no bugs are found, respond with "No bugs found." Be certain of any bug before reporting. Prioritize false positives over false negatives. Include the file name if a bug is found.
+		return `Review the following code and identify any bugs. If no bugs are found, respond with "No bugs found." Be certain of any bug before reporting. Prioritize false positives over false negatives. Report each bug on a line of its own, in the form "path/to/file:LINE: description".
 {{.SourceCode}}`
 	case OpFindTypo:
-		return `Review the following code for typos in comments. If no typos are found, respond with "No typos found." Be certain of any typo before reporting. Prioritize false positives over false negatives. Include the file name if a typo is found.
+		return `Review the following code for typos in comments. If no typos are found, respond with "No typos found." Be certain of any typo before reporting. Prioritize false positives over false negatives. Report each typo on a line of its own, in the form "path/to/file:LINE: description".
+{{.SourceCode}}`
+	case OpGenTest:
+		return `Write table-driven Go unit tests for the following functions, in the same package. Cover normal cases, edge cases and error cases. Follow the style of the existing tests, and only use packages that the existing code or tests already use, in addition to the standard library. Return only the complete contents of a _test.go file, starting with the package clause.
+Existing tests, as style examples: {{.TestExamples}}
+Functions to test: {{.SourceCode}}`
+	case OpGenChangelog:
+		return `Write release notes for version {{.Version}} of this project, in the Keep a Changelog format (https://keepachangelog.com/). Use the following commits, which are grouped and listed with their short hashes: {{.Commits}}
+Sort the changes into "### Added", "### Changed", "### Deprecated", "### Removed", "### Fixed" and "### Security" subsections, and leave out subsections that would be empty. Write one short, user-facing bullet point per change, and combine commits that belong to the same change. Mention breaking changes first, in the subsection they belong to. Leave out changes that only affect tests, formatting or the build, unless they matter to users. Start with the "## [{{.Version}}]" heading. Only return the Markdown for this version, without any introduction.`
+	case OpGenCommitMsg:
+		return `Write a git commit message for these staged changes: {{.Diff}}
+{{.Convention}} The subject line must be at most 72 characters and must not end with a period. After a blank line, write a body that explains what was changed and why, not how. Leave out the body if the change is trivial. Only return the commit message, without any introduction or Markdown formatting.`
+	case OpChat:
+		return `You are answering questions from a developer about this project. This is the README.md file, if there is one: {{.ReadmeContents}}
+These are the parts of the project files that are most relevant for the question, with line numbers in front of each line: {{.SourceCode}}
+This is the conversation so far: {{.History}}
+Answer the following question accurately and concisely, based on the files. Cite the files and lines that the answer is based on, in the form "path/to/file:LINE" or "path/to/file:START-END". If the files do not contain the answer, say so instead of guessing.
+Question: {{.Question}}`
+	case OpGenGodoc:
+		return `Write idiomatic Go doc comments for these exported identifiers, which do not have doc comments yet: {{.Declarations}}
+Each comment must be a complete sentence that starts with the name of the identifier (for methods, the method name without the type), like "Parse reads ..." or "Config holds ...", and should explain what it does or is for, not how. Keep each comment to one or two sentences. Return a JSON object where the keys are the identifiers exactly as given above, like "Type.Method" for methods, and the values are the comment texts without "//". Only return the JSON.
+This is the source file, for context: {{.SourceCode}}`
+	case OpGenDiagram:
+		return `This is the package and import graph of a project, as JSON, where "from" imports "to": {{.Graph}}
+This is the README.md file of the project, if there is one: {{.ReadmeContents}}
+Group the packages into a few architectural layers or components, and write a short description of each package. Do not add packages or imports that are not in the graph. Return JSON in this form, using the package IDs from the graph:
+{"summary": "one paragraph about the architecture", "groups": [{"name": "group name", "packages": ["package ID"]}], "descriptions": {"package ID": "description of at most eight words"}}
+Only return the JSON.`
+	case OpGenOpenAPI:
+		return `Generate an OpenAPI 3.1 specification in {{.SpecFormat}} format for the API server in the following source code. Extract every route from the handler code, with its HTTP method, path parameters, query parameters, headers, request body and the possible responses, with their status codes and response types. Describe the request and response types as schemas under components/schemas, and refer to them with $ref. Use "openapi: 3.1.0", and give each operation a unique operationId. Only include what can be found in the code, and do not mention being an AI.
+If this specification is not blank, it is the specification so far, which should be updated and extended with the routes in the source code below, without removing routes that are not in this part of the source code: {{.PreviousAIAnswer}}
+Only return the {{.SpecFormat}}, without any introduction.
+{{.SourceCode}}`
+	case OpSecurityAudit:
+		return `Audit the following source code for security vulnerabilities, like a security engineer would. Focus on injection, path traversal, weak cryptography, unsafe deserialization, hardcoded secrets and server-side request forgery. Use this checklist: {{.Checklist}}
+Only report issues that can be exploited, and not code quality issues. If there are no vulnerabilities, respond with "No vulnerabilities found." Report each vulnerability on a line of its own, in the form "path/to/file:LINE: [CWE-ID] [SEVERITY] description", where CWE-ID is the most specific CWE, like CWE-89, and SEVERITY is one of critical, high, medium or low. Describe how the vulnerability can be exploited.
 {{.SourceCode}}`
+	case OpReview:
+		return `Review the following changes, given as a unified diff, like an experienced reviewer would before merging. Look for bugs, security issues, missing error handling, unclear naming and code that does not fit the rest of the project. Only comment on the changed lines. If there are no issues, respond with "No issues found." Report each issue on a line of its own, in the form "path/to/file:LINE: comment", where LINE is the line number in the new version of the file.
+Changes: {{.Diff}}
+Related files from the project, for context: {{.SourceCode}}`
 	case OpGenDoc:
 		return `Create comprehensive software documentation in Markdown format. Provide a clear overview of the architecture, components, and interfaces of the software. Describe each component's responsibilities and interactions. Include code snippets and configurations to enhance understanding.
 {{.SourceCode}}`
@@ -87,11 +161,23 @@ func GetFixPrompt(opType OperationType) string {
 	case OpGenCatalog:
 		return `Generate a diff to update or fix the app-catalog.yaml file based on this new app-catalog.yaml file: {{.PreviousAIAnswer}} and this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
 	case OpGenAnyFile:
-		return `Generate a diff to update or fix the file based on this new file: {{.PreviousAIAnswer}} and this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
+		return `Generate a diff to update or fix the files based on these new files: {{.PreviousAIAnswer}} and this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
 	case OpFindBug:
 		return `Generate a diff to fix these bugs: {{.PreviousAIAnswer}} in this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
 	case OpFindTypo:
 		return `Generate a diff to fix these typos: {{.PreviousAIAnswer}} in this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
+	case OpGenTest:
+		return `Generate a diff to fix these generated tests: {{.PreviousAIAnswer}} for this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
+	case OpGenChangelog:
+		return `Generate a diff to fix these release notes: {{.PreviousAIAnswer}} so that they are accurate for these commits: {{.Commits}} If no changes are needed, respond with "No diff needed."`
+	case OpGenCommitMsg:
+		return `Generate an improved version of this commit message: {{.PreviousAIAnswer}} so that it accurately describes these staged changes: {{.Diff}} If no changes are needed, respond with "No changes needed."`
+	case OpGenOpenAPI:
+		return `Generate a diff to update or fix this OpenAPI specification: {{.PreviousAIAnswer}} so that it matches the routes in this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all paths and details are correct.`
+	case OpSecurityAudit:
+		return `Generate a diff to fix these security vulnerabilities: {{.PreviousAIAnswer}} in this project source code: {{.SourceCode}}. Prefer fixes that use the standard library, like parameterized queries and path cleaning. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
+	case OpReview:
+		return `Generate a diff that addresses these review comments: {{.PreviousAIAnswer}} for these changes: {{.Diff}} in this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
 	case OpGenDoc:
 		return `Generate a diff to update or fix the DOC.md file based on this new DOC.md file: {{.PreviousAIAnswer}} and this project source code: {{.SourceCode}}. If no changes are needed, respond with "No diff needed." Ensure all filenames and details are correct.`
 	default:
@@ -108,14 +194,47 @@ func GetConfidencePrompt(opType OperationType) string {
 	case OpGenCatalog:
 		return `How confident are you that this Backstage configuration: {{.PreviousAIAnswer}} is accurate for a project with this source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
 	case OpGenAnyFile:
-		return `How confident are you that this file: {{.PreviousAIAnswer}} is accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
+		return `How confident are you that these files: {{.PreviousAIAnswer}} are accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
 	case OpFindBug:
 		return `How confident are you that these bug findings: {{.PreviousAIAnswer}} are accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
 	case OpFindTypo:
 		return `How confident are you that these typo findings: {{.PreviousAIAnswer}} are accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
+	case OpGenTest:
+		return `How confident are you that these generated tests: {{.PreviousAIAnswer}} are correct for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
+	case OpGenChangelog:
+		return `How confident are you that these release notes: {{.PreviousAIAnswer}} are accurate for these commits: {{.Commits}}? Return a number from 1 to 10. Only return the number.`
+	case OpGenCommitMsg:
+		return `How confident are you that this commit message: {{.PreviousAIAnswer}} accurately describes these staged changes: {{.Diff}}? Return a number from 1 to 10. Only return the number.`
+	case OpGenOpenAPI:
+		return `How confident are you that this OpenAPI specification: {{.PreviousAIAnswer}} is accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
+	case OpSecurityAudit:
+		return `How confident are you that these security findings: {{.PreviousAIAnswer}} are real, exploitable vulnerabilities in this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
+	case OpReview:
+		return `How confident are you that these review comments: {{.PreviousAIAnswer}} are accurate for these changes: {{.Diff}} in this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
 	case OpGenDoc:
 		return `How confident are you that this documentation: {{.PreviousAIAnswer}} is accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
 	default:
 		return `How confident are you that this result: {{.PreviousAIAnswer}} is accurate for this project source code: {{.SourceCode}}? Return a number from 1 to 10. Only return the number.`
 	}
 }
+
+func GetCorrectionPrompt(opType OperationType) string {
+	switch opType {
+	case OpGenCatalog:
+		return `This Backstage catalog-info.yaml file: {{.PreviousAIAnswer}} is not valid. These are the validation errors: {{.ValidationErrors}} Return a corrected catalog-info.yaml file in YAML format, with one YAML document per entity, separated by "---". Only return the YAML.`
+	case OpGenOpenAPI:
+		return `This OpenAPI 3.1 specification: {{.PreviousAIAnswer}} is not valid. These are the validation errors: {{.ValidationErrors}} Return a corrected specification in {{.SpecFormat}} format. Only return the {{.SpecFormat}}.`
+	default:
+		return `This generated file: {{.PreviousAIAnswer}} is not valid. These are the validation errors: {{.ValidationErrors}} Return a corrected version of the file. Only return the file contents.`
+	}
+}
+
+// GetChatSummaryPrompt returns the prompt that is used for summarizing older turns of a chat
+func GetChatSummaryPrompt() string {
+	return `Summarize this conversation about a software project, so that it can be continued later. Keep the facts, the file paths with line numbers and the open questions, and leave out the rest. This is the summary of the conversation before it, if any: {{.History}} This is the conversation: {{.PreviousAIAnswer}} Only return the summary.`
+}
+
+// sourcesAreOptional returns true for operations that can be carried out even if no project files are sent along
+func sourcesAreOptional(opType OperationType) bool {
+	return opType == OpReview || opType == OpGenChangelog || opType == OpGenCommitMsg
+}
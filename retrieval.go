@@ -0,0 +1,186 @@
+package acode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+
+	"github.com/xyproto/projectinfo"
+)
+
+var (
+	// RetrievalCandidates is how many results from each of the lexical and the semantic search are combined by HybridSearch
+	RetrievalCandidates = 50
+	// RetrievalMaxSegments is the maximum number of file segments that are sent when Config.RetrievalQuery is set
+	RetrievalMaxSegments = 40
+)
+
+// vectorStoreFilename returns where the vector store is stored, which is .acode/vectors.json in the project directory by default
+func (cfg *Config) vectorStoreFilename() string {
+	if cfg.VectorStoreFile != "" {
+		return cfg.VectorStoreFile
+	}
+	return filepath.Join(cfg.Directory, ".acode", "vectors.json")
+}
+
+// OpenVectorStore loads the vector store for the project, embeds the files that are new or have changed,
+// and saves it again if anything changed
+func (cfg *Config) OpenVectorStore(project *projectinfo.ProjectInfo, embedder Embedder) (*VectorStore, IndexUpdate, error) {
+	filename := cfg.vectorStoreFilename()
+	vs, err := LoadVectorStore(filename, embedder.Name())
+	if err != nil {
+		return nil, IndexUpdate{}, err
+	}
+	update, err := vs.Update(embedder, project.AllFiles(), cfg.relativeProjectPath)
+	if err != nil {
+		return nil, update, fmt.Errorf("could not embed the project files: %v", err)
+	}
+	if update.Added > 0 || update.Updated > 0 || update.Removed > 0 {
+		if err := vs.Save(filename); err != nil {
+			return vs, update, fmt.Errorf("could not save the vector store: %v", err)
+		}
+	}
+	return vs, update, nil
+}
+
+// HybridSearch combines the lexical BM25 results with the semantic results for the query. The scores from each
+// search are normalized by their best score, and combined as semanticWeight*semantic + (1-semanticWeight)*lexical.
+// If the vector store or the embedder is nil, only the lexical results are used.
+// Returns up to k results that fit within the token budget, the best match first.
+func HybridSearch(idx *SearchIndex, vs *VectorStore, embedder Embedder, query string, k, tokenBudget int, semanticWeight float64) ([]SearchResult, error) {
+	type key struct {
+		path  string
+		start int
+	}
+	var (
+		combined = make(map[key]*SearchResult)
+		order    []key
+	)
+	add := func(results []SearchResult, weight float64) {
+		if len(results) == 0 {
+			return
+		}
+		best := results[0].Score
+		for _, result := range results {
+			k := key{result.Path, result.StartLine}
+			existing, ok := combined[k]
+			if !ok {
+				r := result
+				r.Score = 0
+				existing = &r
+				combined[k] = existing
+				order = append(order, k)
+			}
+			existing.Score += weight * result.Score / best
+		}
+	}
+	lexicalWeight := 1.0
+	if vs != nil && embedder != nil {
+		vectors, err := embedder.Embed([]string{query})
+		if err != nil {
+			return nil, fmt.Errorf("could not embed the query: %v", err)
+		}
+		if len(vectors) == 1 {
+			add(vs.Search(vectors[0], RetrievalCandidates), semanticWeight)
+			lexicalWeight = 1 - semanticWeight
+		}
+	}
+	if idx != nil {
+		add(idx.Search(query, RetrievalCandidates, 0), lexicalWeight)
+	}
+	results := make([]SearchResult, 0, len(order))
+	for _, k := range order {
+		results = append(results, *combined[k])
+	}
+	sortSearchResults(results)
+	return withinBudget(results, k, tokenBudget), nil
+}
+
+// openRetrieval opens the search index, and the vector store if cfg.Embedder is set, the first time they are needed.
+// Problems are reported to status, and the retrieval falls back to what could be opened.
+func (cfg *Config) openRetrieval(status io.Writer, project *projectinfo.ProjectInfo) {
+	if cfg.retrievalOpened {
+		return
+	}
+	cfg.retrievalOpened = true
+	idx, update, err := cfg.OpenSearchIndex(project)
+	if err != nil {
+		fmt.Fprintf(status, "Warning: %v\n", err)
+	}
+	if idx != nil && !cfg.Silent {
+		log.Printf("Search index: %d added, %d updated, %d removed and %d unchanged file(s).\n", update.Added, update.Updated, update.Removed, update.Unchanged)
+	}
+	cfg.searchIndex = idx
+	if cfg.Embedder != nil {
+		vs, update, err := cfg.OpenVectorStore(project, cfg.Embedder)
+		if err != nil {
+			fmt.Fprintf(status, "Warning: %v\n", err)
+		}
+		if vs != nil && !cfg.Silent {
+			log.Printf("Vector store: %d added, %d updated, %d removed and %d unchanged file(s).\n", update.Added, update.Updated, update.Removed, update.Unchanged)
+		}
+		cfg.vectorStore = vs
+	}
+}
+
+// Retrieve returns up to k project file segments that are the most relevant for the query, within the token budget.
+// The BM25 search index is used, combined with semantic search if cfg.Embedder is set.
+func (cfg *Config) Retrieve(status io.Writer, project *projectinfo.ProjectInfo, query string, k, tokenBudget int) ([]SearchResult, error) {
+	cfg.openRetrieval(status, project)
+	if cfg.searchIndex == nil && cfg.vectorStore == nil {
+		return nil, fmt.Errorf("neither the search index nor the vector store could be opened")
+	}
+	var embedder Embedder
+	if cfg.vectorStore != nil {
+		embedder = cfg.Embedder
+	}
+	return HybridSearch(cfg.searchIndex, cfg.vectorStore, embedder, query, k, tokenBudget, cfg.SemanticWeight)
+}
+
+// retrievedChunk returns the segments as a JSON chunk, in the same form as the chunks from Chunk,
+// where each path is followed by the line range of the segment
+func retrievedChunk(results []SearchResult) (string, error) {
+	files := make([]projectinfo.FileInfo, 0, len(results))
+	for _, result := range results {
+		files = append(files, projectinfo.FileInfo{
+			Path:     fmt.Sprintf("%s:%d-%d", result.Path, result.StartLine, result.EndLine),
+			Contents: result.Text,
+		})
+	}
+	data, err := json.Marshal(files)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling chunk: %v", err)
+	}
+	return string(data), nil
+}
+
+// retrievalChunks returns a single chunk with the file segments that are the most relevant for cfg.RetrievalQuery,
+// that fit within the token budget that is left after the bare prompt, and sets cfg.analyzedFiles to their files
+func (cfg *Config) retrievalChunks(status io.Writer, project *projectinfo.ProjectInfo, barePromptTokenCount int) ([]string, error) {
+	budget := int(float64(cfg.Model.MaxTokens-barePromptTokenCount) / PromptMargin)
+	results, err := cfg.Retrieve(status, project, cfg.RetrievalQuery, RetrievalMaxSegments, budget)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if !seen[result.Path] {
+			seen[result.Path] = true
+			cfg.analyzedFiles = append(cfg.analyzedFiles, filepath.FromSlash(result.Path))
+		}
+	}
+	fmt.Fprintf(status, "Retrieved %d segment(s) from %d file(s) for %q.\n", len(results), len(seen), cfg.RetrievalQuery)
+	if !cfg.Silent {
+		log.Printf("Retrieved %d segment(s) from %d file(s) for %q.\n", len(results), len(seen), cfg.RetrievalQuery)
+	}
+	chunk, err := retrievedChunk(results)
+	if err != nil {
+		return nil, err
+	}
+	return []string{chunk}, nil
+}
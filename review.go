@@ -0,0 +1,48 @@
+package acode
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// DefaultReviewContext is the number of lines of context around each change in the diff that is reviewed
+var DefaultReviewContext = 10
+
+// prepareReview computes the diff between cfg.BaseRef and HEAD, and limits the project files to the changed files
+// and the other files in the same directories, which are sent along as context for the review.
+func (cfg *Config) prepareReview(project *projectinfo.ProjectInfo) error {
+	if cfg.BaseRef == "" {
+		return fmt.Errorf("a base ref is needed for reviewing changes")
+	}
+	revisions := cfg.BaseRef + "...HEAD"
+	diff, err := runGit(cfg.Directory, "diff", "--no-color", "--relative", "-U"+strconv.Itoa(DefaultReviewContext), revisions, "--", ".")
+	if err != nil {
+		return err
+	}
+	nameOnly, err := runGit(cfg.Directory, "diff", "--name-only", revisions, "--", ".")
+	if err != nil {
+		return err
+	}
+	changed, err := changedFiles(cfg.Directory, nameOnly)
+	if err != nil {
+		return err
+	}
+	cfg.diff = diff
+
+	relatedDirs := make(map[string]bool)
+	for _, rel := range changed {
+		relatedDirs[filepath.Dir(rel)] = true
+	}
+	cfg.limitProjectFiles(project, func(rel string) bool {
+		return relatedDirs[filepath.Dir(rel)]
+	})
+
+	if !cfg.Silent {
+		log.Printf("Reviewing %d changed file(s) since %s, with %d related file(s) as context.\n", len(changed), cfg.BaseRef, len(project.SourceFiles)+len(project.ConfAndDocFiles))
+	}
+	return nil
+}
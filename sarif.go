@@ -0,0 +1,211 @@
+package acode
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	toolURI      = "https://github.com/xyproto/acode"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string           `json:"id"`
+	Name             string           `json:"name"`
+	ShortDescription sarifMessage     `json:"shortDescription"`
+	HelpURI          string           `json:"helpUri,omitempty"`
+	Properties       *sarifProperties `json:"properties,omitempty"`
+}
+
+type sarifProperties struct {
+	Tags             []string `json:"tags,omitempty"`
+	SecuritySeverity string   `json:"security-severity,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	Fixes     []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	EndLine     int `json:"endLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion   `json:"deletedRegion"`
+	InsertedContent *sarifMessage `json:"insertedContent,omitempty"`
+}
+
+// sarifRules describes the rules for each findings category
+var sarifRules = map[string]sarifRule{
+	"bug":      {ID: "acode/bug", Name: "Bug", ShortDescription: sarifMessage{Text: "Possible bug found by AI code analysis"}},
+	"typo":     {ID: "acode/typo", Name: "Typo", ShortDescription: sarifMessage{Text: "Typo in a comment found by AI code analysis"}},
+	"review":   {ID: "acode/review", Name: "Review", ShortDescription: sarifMessage{Text: "Review comment on a change, from AI code review"}},
+	"security": {ID: "acode/security", Name: "Security", ShortDescription: sarifMessage{Text: "Security issue found by AI security audit"}, Properties: &sarifProperties{Tags: []string{"security"}}},
+}
+
+// sarifRuleFor returns the rule for the category and CWE of the finding
+func sarifRuleFor(f Finding) sarifRule {
+	rule, known := sarifRules[f.Category]
+	if !known {
+		rule = sarifRule{ID: f.RuleID(), Name: f.Category, ShortDescription: sarifMessage{Text: f.Category}}
+	}
+	if f.CWE != "" {
+		name := cweNames[f.CWE]
+		if name == "" {
+			name = f.CWE
+		}
+		rule.ID = f.RuleID()
+		rule.Name = name
+		rule.ShortDescription = sarifMessage{Text: name + " (" + f.CWE + ")"}
+		rule.HelpURI = cweURL(f.CWE)
+		// GitHub code scanning uses the "external/cwe/cwe-N" tags for linking to the CWE
+		rule.Properties = &sarifProperties{Tags: []string{f.Category, "external/cwe/" + strings.ToLower(f.CWE)}}
+	}
+	raiseSecuritySeverity(&rule, f)
+	return rule
+}
+
+// raiseSecuritySeverity sets the security severity of the rule to the one of the finding, if it is higher.
+// GitHub code scanning only reads the security severity from the rule, so findings with the same rule and
+// different severities are reported with the highest one.
+func raiseSecuritySeverity(rule *sarifRule, f Finding) {
+	if f.SecuritySeverity == "" {
+		return
+	}
+	score := securitySeverityScore(f.SecuritySeverity)
+	if rule.Properties == nil {
+		rule.Properties = &sarifProperties{}
+	} else {
+		// The properties of the predefined rules are shared, so they must not be modified
+		properties := *rule.Properties
+		rule.Properties = &properties
+	}
+	current, _ := strconv.ParseFloat(rule.Properties.SecuritySeverity, 64)
+	if newScore, _ := strconv.ParseFloat(score, 64); newScore > current {
+		rule.Properties.SecuritySeverity = score
+	}
+}
+
+// sarifReplacementForHunk converts a hunk to a SARIF replacement of the lines that the hunk changes
+func sarifReplacementForHunk(hunk Hunk) sarifReplacement {
+	oldLines, newLines := hunk.oldAndNew()
+	var replacement sarifReplacement
+	if len(oldLines) == 0 {
+		// An insertion before the line after OldStart, represented as an empty region
+		replacement.DeletedRegion = sarifRegion{StartLine: hunk.OldStart + 1, StartColumn: 1, EndColumn: 1}
+	} else {
+		replacement.DeletedRegion = sarifRegion{StartLine: hunk.OldStart, EndLine: hunk.OldStart + len(oldLines) - 1}
+	}
+	if len(newLines) > 0 {
+		replacement.InsertedContent = &sarifMessage{Text: strings.Join(newLines, "\n") + "\n"}
+	}
+	return replacement
+}
+
+// SARIF returns the given findings as a SARIF 2.1.0 log
+func SARIF(findings []Finding) ([]byte, error) {
+	var (
+		driver    = sarifDriver{Name: "acode", InformationURI: toolURI}
+		ruleIndex = make(map[string]int)
+		results   = []sarifResult{}
+	)
+	for _, f := range findings {
+		index, ok := ruleIndex[f.RuleID()]
+		if !ok {
+			index = len(driver.Rules)
+			driver.Rules = append(driver.Rules, sarifRuleFor(f))
+			ruleIndex[f.RuleID()] = index
+		} else {
+			raiseSecuritySeverity(&driver.Rules[index], f)
+		}
+		location := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: f.Path}}
+		if f.Line > 0 {
+			location.Region = &sarifRegion{StartLine: f.Line, EndLine: f.EndLine}
+		}
+		result := sarifResult{
+			RuleID:    driver.Rules[index].ID,
+			RuleIndex: index,
+			Level:     f.Severity,
+			Message:   sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{{PhysicalLocation: location}},
+		}
+		if len(f.Fixes) > 0 {
+			change := sarifArtifactChange{ArtifactLocation: location.ArtifactLocation}
+			for _, hunk := range f.Fixes {
+				change.Replacements = append(change.Replacements, sarifReplacementForHunk(hunk))
+			}
+			result.Fixes = []sarifFix{{
+				Description:     sarifMessage{Text: "Suggested fix for: " + f.Message},
+				ArtifactChanges: []sarifArtifactChange{change},
+			}}
+		}
+		results = append(results, result)
+	}
+	if driver.Rules == nil {
+		driver.Rules = []sarifRule{}
+	}
+	return json.MarshalIndent(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}, "", "  ")
+}
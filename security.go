@@ -0,0 +1,193 @@
+package acode
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// SecuritySeverities are the severities that security findings can be tagged with, from the most to the least severe
+var SecuritySeverities = []string{"critical", "high", "medium", "low"}
+
+var (
+	cweRegexp              = regexp.MustCompile(`(?i)\[?\bCWE[- ]?(\d+)\b\]?:?\s*`)
+	securitySeverityRegexp = regexp.MustCompile(`(?i)\[(critical|high|medium|low)\]:?\s*`)
+)
+
+// cweNames are the names of the CWEs that the security audit focuses on
+var cweNames = map[string]string{
+	"CWE-22":   "Path traversal",
+	"CWE-78":   "OS command injection",
+	"CWE-79":   "Cross-site scripting",
+	"CWE-89":   "SQL injection",
+	"CWE-94":   "Code injection",
+	"CWE-259":  "Hard-coded password",
+	"CWE-295":  "Improper certificate validation",
+	"CWE-327":  "Broken or risky cryptographic algorithm",
+	"CWE-328":  "Weak hash",
+	"CWE-330":  "Insufficiently random values",
+	"CWE-502":  "Deserialization of untrusted data",
+	"CWE-611":  "XML external entity reference",
+	"CWE-798":  "Hard-coded credentials",
+	"CWE-918":  "Server-side request forgery",
+	"CWE-1333": "Inefficient regular expression complexity",
+}
+
+// securityChecklists has things to look for, for each language from projectinfo.LanguageFromExtension
+var securityChecklists = map[string]string{
+	"Go": `- os/exec with arguments built from user input, or "sh -c" with interpolated strings (CWE-78)
+- database/sql queries built with fmt.Sprintf or string concatenation instead of placeholders (CWE-89)
+- filepath.Join or os.Open with user controlled paths, without checking for ".." (CWE-22)
+- crypto/md5, crypto/sha1, crypto/des or crypto/rc4 for security purposes, and math/rand for secrets (CWE-327, CWE-330)
+- tls.Config with InsecureSkipVerify set to true (CWE-295)
+- http.Get or http.Client requests to URLs that come from user input (CWE-918)
+- text/template instead of html/template for HTML output (CWE-79)
+- encoding/gob or encoding/xml decoding of untrusted data into interface types (CWE-502)`,
+	"Python": `- subprocess with shell=True, os.system or os.popen with user input (CWE-78)
+- SQL queries built with f-strings, % or + instead of parameters (CWE-89)
+- eval, exec or compile with user input (CWE-94)
+- pickle, marshal, shelve or yaml.load without SafeLoader on untrusted data (CWE-502)
+- open or send_file with user controlled paths (CWE-22)
+- hashlib.md5 or hashlib.sha1 for passwords, and the random module for secrets (CWE-327, CWE-330)
+- requests or urllib calls to user supplied URLs, and verify=False (CWE-918, CWE-295)`,
+	"JavaScript": `- child_process.exec with user input (CWE-78)
+- SQL queries built with template literals or concatenation (CWE-89)
+- eval, new Function or setTimeout with strings (CWE-94)
+- innerHTML, dangerouslySetInnerHTML or document.write with user input (CWE-79)
+- path.join or fs calls with user controlled paths (CWE-22)
+- fetch or axios calls to user supplied URLs (CWE-918)
+- Math.random for tokens or secrets, and createHash("md5") or createHash("sha1") (CWE-330, CWE-327)
+- prototype pollution from merging untrusted objects, and unsafe deserialization (CWE-502)`,
+	"Java": `- Runtime.exec or ProcessBuilder with user input (CWE-78)
+- Statement.execute with concatenated SQL instead of PreparedStatement (CWE-89)
+- ObjectInputStream.readObject on untrusted data (CWE-502)
+- XML parsers without external entities disabled (CWE-611)
+- new File or Paths.get with user controlled paths (CWE-22)
+- MessageDigest with MD5 or SHA-1, DES, ECB mode and java.util.Random for secrets (CWE-327, CWE-330)
+- TrustManagers or HostnameVerifiers that accept everything (CWE-295)
+- URL.openConnection with user supplied URLs (CWE-918)`,
+	"C": `- system, popen or exec* with user input (CWE-78)
+- strcpy, strcat, sprintf, gets and unchecked memcpy lengths (CWE-120, CWE-787)
+- format strings that come from user input (CWE-134)
+- integer overflows in size calculations for malloc (CWE-190)
+- fopen with user controlled paths (CWE-22)
+- rand for secrets, and homemade cryptography (CWE-330, CWE-327)`,
+	"Rust": `- unsafe blocks that dereference raw pointers or call FFI functions with unchecked input (CWE-119)
+- std::process::Command with "sh -c" and user input (CWE-78)
+- SQL queries built with format! instead of bind parameters (CWE-89)
+- Path::join with user controlled paths (CWE-22)
+- serde deserialization of untrusted data into types with side effects (CWE-502)
+- danger_accept_invalid_certs or similar TLS options (CWE-295)`,
+	"SQL": `- dynamic SQL built with string concatenation in stored procedures (CWE-89)
+- hard-coded passwords in user creation or connection statements (CWE-259)
+- overly broad GRANT statements (CWE-269)`,
+}
+
+func init() {
+	securityChecklists["TypeScript"] = securityChecklists["JavaScript"]
+	securityChecklists["Kotlin"] = securityChecklists["Java"]
+	securityChecklists["C++"] = securityChecklists["C"]
+	securityChecklists["C/C++ Header"] = securityChecklists["C"]
+}
+
+// genericSecurityChecklist is used for all languages
+const genericSecurityChecklist = `- hard-coded passwords, API keys, tokens and private keys (CWE-798)
+- user input that reaches a shell, an interpreter, a database query or a file path without validation (CWE-78, CWE-94, CWE-89, CWE-22)
+- requests to URLs that are controlled by the user (CWE-918)
+- weak or broken cryptography, and insecure randomness for secrets (CWE-327, CWE-330)
+- deserialization of untrusted data (CWE-502)`
+
+// SecurityChecklist returns the checklist for the given languages, with the generic checklist first.
+// Languages that share a checklist, like C and C++, are listed together, so that the checklist is only included once.
+func SecurityChecklist(languages []string) string {
+	var (
+		checklists []string
+		shared     = make(map[string][]string) // the languages for each checklist
+	)
+	for _, language := range languages {
+		if checklist, ok := securityChecklists[language]; ok {
+			if _, found := shared[checklist]; !found {
+				checklists = append(checklists, checklist)
+			}
+			shared[checklist] = append(shared[checklist], language)
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nFor all languages:\n" + genericSecurityChecklist + "\n")
+	for _, checklist := range checklists {
+		names := shared[checklist]
+		list := names[len(names)-1]
+		if len(names) > 1 {
+			list = strings.Join(names[:len(names)-1], ", ") + " and " + list
+		}
+		fmt.Fprintf(&sb, "\nFor %s:\n%s\n", list, checklist)
+	}
+	return sb.String()
+}
+
+// projectLanguages returns the sorted languages of the source files in the project
+func projectLanguages(project *projectinfo.ProjectInfo) []string {
+	seen := make(map[string]bool)
+	var languages []string
+	for _, file := range project.SourceFiles {
+		if !seen[file.Language] {
+			seen[file.Language] = true
+			languages = append(languages, file.Language)
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// prepareSecurityAudit selects the checklists for the languages that are used in the project
+func (cfg *Config) prepareSecurityAudit(project *projectinfo.ProjectInfo) error {
+	cfg.checklist = SecurityChecklist(projectLanguages(project))
+	return nil
+}
+
+// parseSecurityTags removes the CWE ID and the severity tags from the message of a security finding,
+// and stores them in the finding instead
+func parseSecurityTags(f *Finding) {
+	if m := cweRegexp.FindStringSubmatch(f.Message); m != nil {
+		f.CWE = "CWE-" + m[1]
+		f.Message = strings.TrimSpace(strings.Replace(f.Message, m[0], "", 1))
+	}
+	if m := securitySeverityRegexp.FindStringSubmatch(f.Message); m != nil {
+		f.SecuritySeverity = strings.ToLower(m[1])
+		f.Message = strings.TrimSpace(strings.Replace(f.Message, m[0], "", 1))
+	}
+	if f.SecuritySeverity == "" {
+		f.SecuritySeverity = "medium"
+	}
+	switch f.SecuritySeverity {
+	case "critical", "high":
+		f.Severity = "error"
+	case "medium":
+		f.Severity = "warning"
+	default:
+		f.Severity = "note"
+	}
+}
+
+// securitySeverityScore returns a CVSS-like score for a security severity, as used by the
+// "security-severity" property in SARIF
+func securitySeverityScore(severity string) string {
+	switch severity {
+	case "critical":
+		return "9.5"
+	case "high":
+		return "8.0"
+	case "medium":
+		return "5.5"
+	default:
+		return "2.0"
+	}
+}
+
+// cweURL returns the URL for the description of the given CWE, like "CWE-89"
+func cweURL(cwe string) string {
+	return "https://cwe.mitre.org/data/definitions/" + strings.TrimPrefix(cwe, "CWE-") + ".html"
+}
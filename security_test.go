@@ -0,0 +1,18 @@
+package acode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecurityChecklist(t *testing.T) {
+	checklist := SecurityChecklist([]string{"C", "C++", "C/C++ Header", "Go"})
+	if n := strings.Count(checklist, securityChecklists["C"]); n != 1 {
+		t.Errorf("SecurityChecklist() includes the C checklist %d times, want once", n)
+	}
+	for _, want := range []string{"\nFor C, C++ and C/C++ Header:\n", "\nFor Go:\n", "\nFor all languages:\n"} {
+		if !strings.Contains(checklist, want) {
+			t.Errorf("SecurityChecklist() does not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package acode
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// validators holds the validation functions for the operations that generate files with a well-defined structure
+var validators = map[OperationType]func(string) []error{
+	OpGenCatalog: ValidateCatalog,
+	OpGenOpenAPI: ValidateOpenAPI,
+}
+
+// joinErrors returns the given errors as a bullet list
+func joinErrors(errs []error) string {
+	var sb strings.Builder
+	for _, err := range errs {
+		sb.WriteString("\n- " + err.Error())
+	}
+	return sb.String() + "\n"
+}
+
+// validateAndRetry validates the generated file, and asks the AI to correct it, up to cfg.ValidationRetries times.
+// Returns the valid file, the cost of the correction prompts and an error if the file is still not valid.
+func (cfg *Config) validateAndRetry(status io.Writer, validate func(string) []error, generated string) (string, float64, error) {
+	var totalUSDCost float64
+	for attempt := 1; ; attempt++ {
+		errs := validate(generated)
+		if len(errs) == 0 {
+			return generated, totalUSDCost, nil
+		}
+		if attempt > cfg.ValidationRetries {
+			return "", totalUSDCost, fmt.Errorf("the generated file is not valid after %d correction attempt(s):%s", cfg.ValidationRetries, strings.TrimRight(joinErrors(errs), "\n"))
+		}
+		fmt.Fprintf(status, "The generated file has %d validation error(s), asking for a correction (attempt %d of %d)...\n", len(errs), attempt, cfg.ValidationRetries)
+		if !cfg.Silent {
+			log.Printf("The generated file has %d validation error(s), asking for a correction (attempt %d of %d)...\n", len(errs), attempt, cfg.ValidationRetries)
+		}
+		prompt, err := cfg.BuildPrompt(GetCorrectionPrompt(cfg.OpType), TemplateData{
+			PreviousAIAnswer: "\n\n" + generated + "\n",
+			ValidationErrors: "\n" + joinErrors(errs),
+			SpecFormat:       cfg.specFormat(),
+		})
+		if err != nil {
+			return "", totalUSDCost, err
+		}
+		response, usdCost, err := cfg.postAndReport(status, "[correction] ", prompt, cfg.CountPromptTokens(prompt))
+		totalUSDCost += usdCost
+		if err != nil {
+			return "", totalUSDCost, err
+		}
+		generated = strings.TrimSpace(trimCodeBlockMarkers(strings.TrimSpace(response)))
+	}
+}
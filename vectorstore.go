@@ -0,0 +1,165 @@
+package acode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// vectorStoreVersion is increased when the format of the vector store changes, so that old stores are rebuilt
+const vectorStoreVersion = 1
+
+// VectorSegment is a file segment with its embedding
+type VectorSegment struct {
+	StartLine int       `json:"start"`
+	EndLine   int       `json:"end"`
+	Text      string    `json:"text"`
+	Vector    []float32 `json:"vector"`
+}
+
+// VectorFile is a file in the vector store, with the hash of the contents that were embedded
+type VectorFile struct {
+	Hash     string          `json:"hash"`
+	Segments []VectorSegment `json:"segments"`
+}
+
+// VectorStore holds the embeddings of the project file segments, and is stored as a file
+type VectorStore struct {
+	Version  int                    `json:"version"`
+	Embedder string                 `json:"embedder"` // the name of the embedder that made the vectors
+	Files    map[string]*VectorFile `json:"files"`    // by path, relative to the project directory
+}
+
+// LoadVectorStore reads a vector store from disk. If the file does not exist, was written by an incompatible version,
+// or holds vectors from another embedder, an empty store is returned.
+func LoadVectorStore(filename, embedderName string) (*VectorStore, error) {
+	empty := &VectorStore{Version: vectorStoreVersion, Embedder: embedderName, Files: make(map[string]*VectorFile)}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return empty, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read the vector store: %v", err)
+	}
+	var vs VectorStore
+	if err := json.Unmarshal(data, &vs); err != nil {
+		return nil, fmt.Errorf("could not parse the vector store %s: %v", filename, err)
+	}
+	if vs.Version != vectorStoreVersion || vs.Embedder != embedderName || vs.Files == nil {
+		return empty, nil
+	}
+	return &vs, nil
+}
+
+// Save writes the vector store to disk atomically
+func (vs *VectorStore) Save(filename string) error {
+	data, err := json.Marshal(vs)
+	if err != nil {
+		return fmt.Errorf("could not encode the vector store: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("could not create the directory for the vector store: %v", err)
+	}
+	return writeFileAtomic(filename, data, 0644)
+}
+
+// Update embeds the segments of the files that are new or that have changed since they were embedded,
+// by comparing content hashes, and removes files that are no longer in the project.
+// The files use the same segments as the search index. The paths are made relative with the given function.
+func (vs *VectorStore) Update(embedder Embedder, files []projectinfo.FileInfo, relativePath func(string) string) (IndexUpdate, error) {
+	var (
+		update  IndexUpdate
+		seen    = make(map[string]bool)
+		pending = make(map[string]*VectorFile)
+		texts   []string
+	)
+	for _, file := range files {
+		path := filepath.ToSlash(relativePath(file.Path))
+		seen[path] = true
+		hash := contentHash(file.Contents)
+		existing, ok := vs.Files[path]
+		if ok && existing.Hash == hash {
+			update.Unchanged++
+			continue
+		}
+		if ok {
+			update.Updated++
+		} else {
+			update.Added++
+		}
+		vf := &VectorFile{Hash: hash}
+		for _, segment := range segmentFile(file.Contents) {
+			vf.Segments = append(vf.Segments, VectorSegment{StartLine: segment.StartLine, EndLine: segment.EndLine, Text: segment.Text})
+			texts = append(texts, segment.Text)
+		}
+		pending[path] = vf
+	}
+	if len(texts) > 0 {
+		vectors, err := embedder.Embed(texts)
+		if err != nil {
+			return update, err
+		}
+		if len(vectors) != len(texts) {
+			return update, fmt.Errorf("the embedder returned %d vectors for %d texts", len(vectors), len(texts))
+		}
+		// Hand out the vectors in the same order as the texts were collected
+		i := 0
+		for _, file := range files {
+			vf, ok := pending[filepath.ToSlash(relativePath(file.Path))]
+			if !ok {
+				continue
+			}
+			for j := range vf.Segments {
+				vf.Segments[j].Vector = vectors[i]
+				i++
+			}
+		}
+	}
+	for path, vf := range pending {
+		vs.Files[path] = vf
+	}
+	for path := range vs.Files {
+		if !seen[path] {
+			delete(vs.Files, path)
+			update.Removed++
+		}
+	}
+	return update, nil
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, or 0 if they can not be compared
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Search returns the k segments that are most similar to the query vector, by cosine similarity, the best match first
+func (vs *VectorStore) Search(query []float32, k int) []SearchResult {
+	var results []SearchResult
+	for path, file := range vs.Files {
+		for _, segment := range file.Segments {
+			if score := cosineSimilarity(query, segment.Vector); score > 0 {
+				results = append(results, SearchResult{Path: path, StartLine: segment.StartLine, EndLine: segment.EndLine, Text: segment.Text, Score: score})
+			}
+		}
+	}
+	sortSearchResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
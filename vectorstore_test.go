@@ -0,0 +1,53 @@
+package acode
+
+import (
+	"testing"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// fakeEmbedder returns a vector per text with the length of the text, and drops the last vector if short is set
+type fakeEmbedder struct {
+	short bool
+}
+
+func (e fakeEmbedder) Embed(texts []string) ([][]float32, error) {
+	var vectors [][]float32
+	for _, text := range texts {
+		vectors = append(vectors, []float32{float32(len(text)), 1})
+	}
+	if e.short {
+		vectors = vectors[:len(vectors)-1]
+	}
+	return vectors, nil
+}
+
+func (fakeEmbedder) Name() string { return "fake" }
+
+func TestVectorStoreUpdate(t *testing.T) {
+	relative := func(path string) string { return path }
+	files := []projectinfo.FileInfo{
+		{Path: "a.go", Contents: "package a\n"},
+		{Path: "b.go", Contents: "package b\n\nfunc B() {}\n"},
+	}
+
+	vs := &VectorStore{Embedder: "fake", Files: make(map[string]*VectorFile)}
+	update, err := vs.Update(fakeEmbedder{}, files, relative)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (IndexUpdate{Added: 2}); update != want {
+		t.Errorf("Update() = %+v, want %+v", update, want)
+	}
+	if got := vs.Files["b.go"].Segments[0].Vector; len(got) != 2 || got[0] != float32(len(files[1].Contents)-1) {
+		t.Errorf("Update() gave b.go the vector %v", got)
+	}
+
+	vs = &VectorStore{Embedder: "fake", Files: make(map[string]*VectorFile)}
+	if _, err := vs.Update(fakeEmbedder{short: true}, files, relative); err == nil {
+		t.Error("Update() expected an error when the embedder returns too few vectors")
+	}
+	if len(vs.Files) != 0 {
+		t.Errorf("Update() stored %d files after an embedder error, want none", len(vs.Files))
+	}
+}
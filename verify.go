@@ -0,0 +1,171 @@
+package acode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/projectinfo"
+)
+
+// VerificationStatus is the outcome of building and testing a single fix
+type VerificationStatus int
+
+// The different outcomes of verifying a fix
+const (
+	NotVerified         VerificationStatus = iota // the fix could not be applied or verified
+	Verified                                      // the project builds and the tests pass with the fix applied
+	BrokeTheBuild                                 // one of the build commands failed with the fix applied
+	FailedTests                                   // one of the test commands failed with the fix applied
+	TestsAlreadyFailing                           // the project builds with the fix applied, but the tests failed even without it
+)
+
+// FixVerification holds the verification result for the patch of a single file
+type FixVerification struct {
+	Path     string
+	Status   VerificationStatus
+	Output   string // output from the command that failed, if any
+	Rejected []HunkRejection
+}
+
+// String returns a short description of the verification status
+func (s VerificationStatus) String() string {
+	switch s {
+	case Verified:
+		return "verified"
+	case BrokeTheBuild:
+		return "broke the build"
+	case FailedTests:
+		return "failed tests"
+	case TestsAlreadyFailing:
+		return "builds, but the tests were already failing"
+	default:
+		return "not verified"
+	}
+}
+
+// runCommands runs the given commands in the given directory, and stops at the first one that fails.
+// Each command is split into arguments at whitespace, without a shell, so quoted arguments are not supported.
+// Commands that need quoting or shell features can be placed in a script, and the script can be given as the command.
+// Returns the combined output of the failing command, if any.
+func (cfg *Config) runCommands(dir string, commands []string) (string, error) {
+	for _, command := range commands {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+		cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		cancel()
+		if err != nil {
+			return string(output), fmt.Errorf("%s: %v", command, err)
+		}
+	}
+	return "", nil
+}
+
+// VerifyPatches applies each fix from the combined fix responses, one file at a time, to a scratch copy of cfg.Directory,
+// and runs cfg.BuildCommands and cfg.TestCommands to check if the fix breaks the build or the tests.
+func (cfg *Config) VerifyPatches(status io.Writer, project *projectinfo.ProjectInfo, combinedFixResponses string) ([]FixVerification, error) {
+	var verifications []FixVerification
+
+	patches, rejected := cfg.ValidatePatches(project, ExtractPatches(combinedFixResponses))
+	for _, r := range rejected {
+		verifications = append(verifications, FixVerification{Path: r.Path, Status: NotVerified, Rejected: []HunkRejection{r}})
+	}
+	if len(patches) == 0 {
+		return verifications, nil
+	}
+
+	scratchDir, err := CopyToScratch(cfg.Directory)
+	if err != nil {
+		return verifications, err
+	}
+	defer os.RemoveAll(scratchDir)
+
+	// Check that the project builds before any fixes are applied, and if the tests passed to begin with
+	if output, err := cfg.runCommands(scratchDir, cfg.BuildCommands); err != nil {
+		return verifications, fmt.Errorf("the project does not build without the fixes: %v\n%s", err, output)
+	}
+	_, err = cfg.runCommands(scratchDir, cfg.TestCommands)
+	testsPassedBefore := err == nil
+	if !testsPassedBefore {
+		fmt.Fprintln(status, "The tests fail even without the fixes, so a fix can only be verified if it makes them pass.")
+	}
+
+	for _, fp := range patches {
+		verification := FixVerification{Path: fp.Path()}
+		filename := filepath.Join(scratchDir, fp.Path())
+		original, readErr := os.ReadFile(filename)
+		info, statErr := os.Stat(filename)
+
+		report := &PatchReport{Directory: scratchDir}
+		if err := applyFilePatches(scratchDir, []FilePatch{fp}, report); err != nil {
+			return verifications, err
+		}
+		verification.Rejected = report.Rejected
+
+		if report.AppliedHunks > 0 {
+			verification.Status = Verified
+			if output, err := cfg.runCommands(scratchDir, cfg.BuildCommands); err != nil {
+				verification.Status = BrokeTheBuild
+				verification.Output = strings.TrimSpace(fmt.Sprintf("%v\n%s", err, output))
+			} else if output, err := cfg.runCommands(scratchDir, cfg.TestCommands); err != nil {
+				verification.Status = FailedTests
+				if !testsPassedBefore {
+					verification.Status = TestsAlreadyFailing
+				}
+				verification.Output = strings.TrimSpace(fmt.Sprintf("%v\n%s", err, output))
+			}
+		}
+
+		// Restore the file, so that the next fix is verified on its own
+		if readErr == nil && statErr == nil {
+			if err := os.WriteFile(filename, original, info.Mode().Perm()); err != nil {
+				return verifications, fmt.Errorf("could not restore %s: %v", filename, err)
+			}
+			// os.WriteFile does not change the mode of an existing file
+			if err := os.Chmod(filename, info.Mode()); err != nil {
+				return verifications, fmt.Errorf("could not restore the mode of %s: %v", filename, err)
+			}
+		} else {
+			os.Remove(filename)
+		}
+
+		fmt.Fprintf(status, "Fix for %s: %s\n", fp.Path(), verification.Status)
+		if !cfg.Silent {
+			log.Printf("Fix for %s: %s\n", fp.Path(), verification.Status)
+		}
+		verifications = append(verifications, verification)
+	}
+	return verifications, nil
+}
+
+// adjustConfidence combines the confidence from the AI (1 to 10) with the share of fixes that could be verified.
+// Fixes that could not be tested, since the tests were already failing, are left out.
+func adjustConfidence(confidence int, verifications []FixVerification) int {
+	verified, counted := 0, 0
+	for _, v := range verifications {
+		switch v.Status {
+		case TestsAlreadyFailing:
+			continue
+		case Verified:
+			verified++
+		}
+		counted++
+	}
+	if counted == 0 {
+		return confidence
+	}
+	verifiedScore := 1.0 + 9.0*float64(verified)/float64(counted)
+	adjusted := int(math.Round((float64(confidence) + verifiedScore) / 2.0))
+	return max(1, min(10, adjusted))
+}
@@ -0,0 +1,28 @@
+package acode
+
+import "testing"
+
+func TestAdjustConfidence(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []VerificationStatus
+		want     int
+	}{
+		{"no fixes", nil, 6},
+		{"all verified", []VerificationStatus{Verified, Verified}, 8},
+		{"none verified", []VerificationStatus{BrokeTheBuild, FailedTests}, 4},
+		{"tests already failing are left out", []VerificationStatus{TestsAlreadyFailing, TestsAlreadyFailing}, 6},
+		{"tests already failing do not count as verified", []VerificationStatus{Verified, TestsAlreadyFailing}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var verifications []FixVerification
+			for _, status := range tt.statuses {
+				verifications = append(verifications, FixVerification{Status: status})
+			}
+			if got := adjustConfidence(6, verifications); got != tt.want {
+				t.Errorf("adjustConfidence() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package acode
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xyproto/projectinfo"
+)
+
+const (
+	// DefaultWatchInterval is how often the project files are checked for changes, if Config.WatchInterval is not set
+	DefaultWatchInterval = time.Second
+
+	// DefaultWatchDebounce is how long the project files must be left unchanged before they are analyzed again,
+	// if Config.WatchDebounce is not set
+	DefaultWatchDebounce = 2 * time.Second
+)
+
+// fileState is the modification time and size of a project file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchable checks if the given operation can be used in watch mode
+func watchable(opType OperationType) bool {
+	switch opType {
+	case OpGenDoc, OpGenAPI, OpGenReadme, OpFindBug, OpFindTypo, OpSecurityAudit:
+		return true
+	}
+	return false
+}
+
+// reportsFindings checks if the given operation reports findings, instead of generating a document
+func reportsFindings(opType OperationType) bool {
+	switch opType {
+	case OpFindBug, OpFindTypo, OpReview, OpSecurityAudit:
+		return true
+	}
+	return false
+}
+
+// snapshotFiles returns the modification time and size of each file that projectinfo would collect, by path relative
+// to cfg.Directory. This only stats the files, so that polling is cheap. The output file and .acode/ are left out,
+// since they are written to by the watch mode itself.
+func (cfg *Config) snapshotFiles() (map[string]fileState, error) {
+	ignores, err := projectinfo.LoadIgnorePatterns(filepath.Join(cfg.Directory, ".ignore"), filepath.Join(cfg.Directory, ".gitignore"))
+	if err != nil {
+		return nil, err
+	}
+	outputFilename := ""
+	if cfg.OutputFilename != "" && cfg.OutputFilename != "-" {
+		outputFilename, _ = filepath.Abs(cfg.OutputFilename)
+	}
+	snapshot := make(map[string]fileState)
+	err = filepath.WalkDir(cfg.Directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // the file may have been removed while walking
+		}
+		if (path != cfg.Directory && projectinfo.ShouldSkip(path, ignores)) || d.Name() == ".acode" {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !(projectinfo.RecognizedExtension(path, false) || projectinfo.RecognizedExtension(path, true) || projectinfo.RecognizedFilename(path, true)) {
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err == nil && abs == outputFilename {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		snapshot[cfg.relativeProjectPath(path)] = fileState{info.ModTime(), info.Size()}
+		return nil
+	})
+	return snapshot, err
+}
+
+// modifiedFiles returns the files that are new or changed in the current snapshot, compared to the previous one
+func modifiedFiles(previous, current map[string]fileState) []string {
+	var modified []string
+	for rel, state := range current {
+		if old, ok := previous[rel]; !ok || !old.modTime.Equal(state.modTime) || old.size != state.size {
+			modified = append(modified, rel)
+		}
+	}
+	return modified
+}
+
+// Watch polls cfg.Directory for changes, and re-runs the selected operation for the changed files, once they have
+// been left unchanged for cfg.WatchDebounce. The findings that have not been reported before in this session are
+// written to out. Documentation is generated for each changed file, and merged into the output file with
+// MergeFileSections. A README describes the whole project and can not be generated per file, so it is only
+// regenerated, for the whole project, if cfg.WatchRegenerate is set. The changes to the output file are confirmed
+// as a diff, so either cfg.Force or cfg.Confirmer must be set. Watching continues until stop is closed, or forever
+// if stop is nil. Returns the cost in USD for the whole session.
+func (cfg *Config) Watch(status, out io.Writer, stop <-chan struct{}) (float64, error) {
+	if !watchable(cfg.OpType) {
+		return 0, fmt.Errorf("the selected operation can not be used in watch mode")
+	}
+	if cfg.OpType == OpGenReadme && !cfg.WatchRegenerate {
+		return 0, fmt.Errorf("the README can not be generated per file, so WatchRegenerate must be set for regenerating it for the whole project on every change")
+	}
+	// Check this before anything is sent, instead of paying for every run and then not writing the result
+	if !reportsFindings(cfg.OpType) && cfg.OutputFilename != "" && cfg.OutputFilename != "-" && !cfg.Force && cfg.Confirmer == nil {
+		return 0, fmt.Errorf("watch mode updates %s on every change, so either Force or a Confirmer must be set", cfg.OutputFilename)
+	}
+	interval, debounce := cfg.WatchInterval, cfg.WatchDebounce
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	cfg.watching = true
+	defer func() { cfg.watching = false }()
+
+	previous, err := cfg.snapshotFiles()
+	if err != nil {
+		return 0, fmt.Errorf("could not list the files in %s: %v", cfg.Directory, err)
+	}
+
+	var (
+		totalUSDCost float64
+		runs         int
+		pending      = make(map[string]bool)
+		lastChange   time.Time
+		seen         = make(map[string]bool) // the findings that have been reported in this session
+		ticker       = time.NewTicker(interval)
+	)
+	defer ticker.Stop()
+
+	fmt.Fprintf(status, "Watching %s for changes...\n", cfg.Directory)
+	if !cfg.Silent {
+		log.Printf("Watching %s for changes, checking every %v\n", cfg.Directory, interval)
+	}
+
+	for {
+		select {
+		case <-stop:
+			fmt.Fprintf(status, "Stopped watching after %d run(s). Approximate cost for the session: $%.2f\n", runs, totalUSDCost)
+			return totalUSDCost, nil
+		case <-ticker.C:
+		}
+
+		current, err := cfg.snapshotFiles()
+		if err != nil {
+			fmt.Fprintf(status, "Warning: could not list the files in %s: %v\n", cfg.Directory, err)
+			continue
+		}
+		for _, rel := range modifiedFiles(previous, current) {
+			pending[rel] = true
+			lastChange = time.Now()
+		}
+		previous = current
+		if len(pending) == 0 || time.Since(lastChange) < debounce {
+			continue
+		}
+
+		changed := sortedSet(pending)
+		pending = make(map[string]bool)
+		usdCost, err := cfg.watchRun(status, out, changed, seen)
+		totalUSDCost += usdCost
+		runs++
+		if err != nil {
+			fmt.Fprintf(status, "Warning: %v\n", err)
+			if !cfg.Silent {
+				log.Printf("Warning: %v\n", err)
+			}
+		}
+		fmt.Fprintf(status, "Approximate cost for this run: $%.2f, and for the session: $%.2f\n", usdCost, totalUSDCost)
+	}
+}
+
+// watchRun re-runs the operation after the given files have changed. Findings are looked for in the changed files only,
+// and the new ones are written to out. Documentation is generated per changed file and merged into the output file,
+// apart from the README, which is regenerated for the whole project. Returns the cost in USD.
+func (cfg *Config) watchRun(status, out io.Writer, changed []string, seen map[string]bool) (float64, error) {
+	project, err := projectinfo.New(cfg.Directory, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := cfg.prepareOperation(&project); err != nil {
+		return 0, err
+	}
+	keep := make(map[string]bool, len(changed))
+	for _, rel := range changed {
+		keep[rel] = true
+	}
+	var analyzed []string
+	collectChanged := func(files []projectinfo.FileInfo) {
+		for _, file := range files {
+			if rel := cfg.relativeProjectPath(file.Path); keep[rel] {
+				analyzed = append(analyzed, rel)
+			}
+		}
+	}
+	if !cfg.ExcludeSources {
+		collectChanged(project.SourceFiles)
+	}
+	if cfg.IncludeConfAndDoc {
+		collectChanged(project.ConfAndDocFiles)
+	}
+	if len(analyzed) == 0 {
+		return 0, nil // none of the changed files are analyzed by this operation
+	}
+	sort.Strings(analyzed)
+
+	// Each run gets its own run journal
+	cfg.RunID = ""
+	cfg.Resume = false
+
+	switch {
+	case cfg.OpType == OpGenReadme:
+		fmt.Fprintf(status, "Regenerating the README after changes to: %s\n", strings.Join(analyzed, ", "))
+		response, _, _, usdCost, err := cfg.Process(status, &project)
+		if err != nil || strings.HasPrefix(response, "No documentation generated") {
+			return usdCost, err
+		}
+		return usdCost, cfg.OutputResults(response, "")
+	case !reportsFindings(cfg.OpType):
+		return cfg.watchDocRun(status, &project, analyzed)
+	}
+
+	cfg.limitProjectFiles(&project, func(rel string) bool {
+		return keep[rel]
+	})
+	fmt.Fprintf(status, "Analyzing %d changed file(s): %s\n", len(analyzed), strings.Join(analyzed, ", "))
+	response, _, _, usdCost, err := cfg.Process(status, &project)
+	if err != nil {
+		return usdCost, err
+	}
+	var reported int
+	for _, f := range ParseFindings(cfg.OpType, response) {
+		key := f.Category + "\x00" + f.Path + "\x00" + f.Message
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		reported++
+		if f.Line > 0 {
+			fmt.Fprintf(out, "%s:%d: %s\n", f.Path, f.Line, f.Message)
+		} else {
+			fmt.Fprintf(out, "%s: %s\n", f.Path, f.Message)
+		}
+	}
+	fmt.Fprintf(status, "%d new finding(s).\n", reported)
+	return usdCost, nil
+}
+
+// watchDocRun generates the documentation for each of the changed files, and merges it into the output file,
+// one section per file. Returns the cost in USD.
+func (cfg *Config) watchDocRun(status io.Writer, project *projectinfo.ProjectInfo, changed []string) (float64, error) {
+	var (
+		totalUSDCost float64
+		docs         = make(map[string]string)
+	)
+	for _, rel := range changed {
+		fmt.Fprintf(status, "Generating the documentation for %s...\n", rel)
+		single := *project
+		cfg.limitProjectFiles(&single, func(path string) bool {
+			return path == rel
+		})
+		cfg.RunID = ""
+		response, _, _, usdCost, err := cfg.Process(status, &single)
+		totalUSDCost += usdCost
+		if err != nil {
+			return totalUSDCost, err
+		}
+		if !strings.HasPrefix(response, "No documentation generated") {
+			docs[rel] = response
+		}
+	}
+	if len(docs) == 0 {
+		return totalUSDCost, nil
+	}
+	existing := ""
+	if cfg.OutputFilename != "" && cfg.OutputFilename != "-" {
+		if data, err := os.ReadFile(cfg.OutputFilename); err == nil {
+			existing = string(data)
+		} else if !os.IsNotExist(err) {
+			return totalUSDCost, fmt.Errorf("failed to read %s: %v", cfg.OutputFilename, err)
+		}
+	}
+	return totalUSDCost, cfg.OutputResults(MergeFileSections(existing, docs), "")
+}
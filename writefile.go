@@ -0,0 +1,178 @@
+package acode
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xyproto/files"
+)
+
+// backupTimeFormat is the timestamp that backups are prefixed with. It has a fixed width, so that the backups sort in
+// chronological order, and nanoseconds, so that backups that are made within the same second do not overwrite each other.
+const backupTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// oldBackupTimeFormat is the timestamp of backups made with files.TimestampedFilename by earlier versions
+const oldBackupTimeFormat = "2006-01-02T15-04-05"
+
+// writeFileAtomic writes the data to a temporary file in the same directory as filename, and then renames it to filename,
+// so that filename is never left empty or half-written. The permissions of an existing file are kept.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	if fi, err := os.Stat(filename); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	if err := os.Chmod(tempName, perm); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	if err := os.Rename(tempName, filename); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	return nil
+}
+
+// AcodeDirectory is the name of the directories where backups, caches and indexes are kept
+const AcodeDirectory = ".acode"
+
+// inAcodeDirectory checks if any component of the given path is an .acode directory
+func inAcodeDirectory(path string) bool {
+	for _, component := range strings.Split(filepath.ToSlash(path), "/") {
+		if component == AcodeDirectory {
+			return true
+		}
+	}
+	return false
+}
+
+// backupDirectory returns the directory where backups of the given file are placed. All backups are kept below
+// one root directory, which is .acode/backups in cfg.Directory by default, in the same relative directory as the file.
+// Files outside of cfg.Directory are backed up to .acode/backups next to the file, which is also outside of the project.
+func (cfg *Config) backupDirectory(filename string) string {
+	root := cfg.BackupDir
+	if root == "" {
+		root = filepath.Join(cfg.Directory, AcodeDirectory, "backups")
+	}
+	dir, err1 := filepath.Abs(filepath.Dir(filename))
+	projectDir, err2 := filepath.Abs(cfg.Directory)
+	if err1 == nil && err2 == nil {
+		if rel, err := filepath.Rel(projectDir, dir); err == nil && insideDirectory(rel) {
+			return filepath.Join(root, rel)
+		}
+	}
+	if cfg.BackupDir != "" {
+		return cfg.BackupDir
+	}
+	return filepath.Join(filepath.Dir(filename), AcodeDirectory, "backups")
+}
+
+// BackupFile copies the given file to a timestamped file in the backup directory, if the file exists and backups are enabled.
+// Old backups of the same file are removed according to cfg.BackupRetention and cfg.BackupMaxAge.
+// Returns the path to the backup, or a blank string if no backup was made.
+func (cfg *Config) BackupFile(filename string) (string, error) {
+	if cfg.BackupRetention <= 0 || !files.IsFile(filename) {
+		return "", nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s for making a backup: %v", filename, err)
+	}
+	backupDir := cfg.backupDirectory(filename)
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return "", fmt.Errorf("could not create backup directory %s: %v", backupDir, err)
+	}
+	var (
+		backupFilename string
+		now            = time.Now()
+	)
+	for {
+		backupFilename = filepath.Join(backupDir, now.Format(backupTimeFormat)+"-"+filepath.Base(filename))
+		if !files.Exists(backupFilename) {
+			break
+		}
+		now = now.Add(time.Nanosecond) // the clock may have a lower resolution than nanoseconds
+	}
+	if err := writeFileAtomic(backupFilename, data, 0644); err != nil {
+		return "", fmt.Errorf("could not write backup %s: %v", backupFilename, err)
+	}
+	if err := cfg.pruneBackups(backupDir, filepath.Base(filename)); err != nil {
+		return backupFilename, err
+	}
+	return backupFilename, nil
+}
+
+// isBackupOf checks if the given name is a backup of the file with the given base name, in the current or the old format
+func isBackupOf(name, base string) bool {
+	timestamp := strings.TrimSuffix(name, "-"+base)
+	if timestamp == name {
+		return false
+	}
+	for _, layout := range []string{backupTimeFormat, oldBackupTimeFormat} {
+		if _, err := time.Parse(layout, timestamp); err == nil && len(timestamp) == len(layout) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneBackups removes the oldest backups of the file with the given base name, so that at most cfg.BackupRetention are kept,
+// and removes backups that are older than cfg.BackupMaxAge, if it is set.
+func (cfg *Config) pruneBackups(backupDir, base string) error {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return fmt.Errorf("could not list backups in %s: %v", backupDir, err)
+	}
+	var backups []string // the timestamps sort in chronological order
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && isBackupOf(name, base) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, name := range backups {
+		path := filepath.Join(backupDir, name)
+		tooOld := false
+		if cfg.BackupMaxAge > 0 {
+			if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > cfg.BackupMaxAge {
+				tooOld = true
+			}
+		}
+		if i >= cfg.BackupRetention || tooOld {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("could not remove old backup %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeOutputFile makes a backup of the given file, if it exists, and then replaces it atomically with the given data
+func (cfg *Config) writeOutputFile(filename string, data []byte) error {
+	if _, err := cfg.BackupFile(filename); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data, 0644)
+}
@@ -0,0 +1,96 @@
+package acode
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBackupDirectory(t *testing.T) {
+	project := t.TempDir()
+	outside := t.TempDir()
+	tests := []struct {
+		name      string
+		backupDir string
+		filename  string
+		want      string
+	}{
+		{"file in the project root", "", filepath.Join(project, "README.md"), filepath.Join(project, ".acode", "backups")},
+		{"file in a package", "", filepath.Join(project, "pkg", "a.go"), filepath.Join(project, ".acode", "backups", "pkg")},
+		{"file outside of the project", "", filepath.Join(outside, "DOC.md"), filepath.Join(outside, ".acode", "backups")},
+		{"custom backup directory", "/backups", filepath.Join(project, "pkg", "a.go"), filepath.Join("/backups", "pkg")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Directory: project, BackupDir: tt.backupDir}
+			if got := cfg.backupDirectory(tt.filename); got != tt.want {
+				t.Errorf("backupDirectory(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInAcodeDirectory(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{".acode/backups/2024-01-01T00-00-00-README.md", true},
+		{"pkg/.acode/backups/2024-01-01T00-00-00-a.go", true},
+		{"pkg/a.go", false},
+		{"pkg/.acodex/a.go", false},
+	}
+	for _, tt := range tests {
+		if got := inAcodeDirectory(tt.path); got != tt.want {
+			t.Errorf("inAcodeDirectory(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestBackupFileKeepsEveryBackup(t *testing.T) {
+	project := t.TempDir()
+	filename := filepath.Join(project, "DOC.md")
+	cfg := &Config{Directory: project, BackupRetention: 2}
+	for _, contents := range []string{"first", "second", "third"} {
+		if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.BackupFile(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(cfg.backupDirectory(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backups []string
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(cfg.backupDirectory(filename), entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, string(data))
+	}
+	if want := []string{"second", "third"}; !reflect.DeepEqual(backups, want) {
+		t.Errorf("the backups are %q, want %q", backups, want)
+	}
+}
+
+func TestIsBackupOf(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"2026-10-18T12-00-00.123456789-README.md", true},
+		{"2026-10-18T12-00-00-README.md", true},
+		{"2026-10-18T12-00-00.123-README.md", false},
+		{"2026-10-18T12-00-00.123456789-OTHER.md", false},
+		{"README.md", false},
+	}
+	for _, tt := range tests {
+		if got := isBackupOf(tt.name, "README.md"); got != tt.want {
+			t.Errorf("isBackupOf(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
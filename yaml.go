@@ -0,0 +1,108 @@
+package acode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseYAML parses all documents in the given YAML string.
+// Mappings are returned as map[string]interface{}, sequences as []interface{} and all scalars as strings,
+// so that the validators can check the values the same way, regardless of how YAML would resolve them.
+// Empty documents are skipped.
+func ParseYAML(s string) ([]interface{}, error) {
+	var docs []interface{}
+	decoder := yaml.NewDecoder(strings.NewReader(s))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); errors.Is(err, io.EOF) {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		value, err := yamlNodeValue(node.Content[0], 0)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, value)
+	}
+}
+
+// maxYAMLDepth limits how deeply aliases can be expanded, so that recursive aliases do not loop forever
+const maxYAMLDepth = 100
+
+// yamlNodeValue converts a parsed YAML node to a map[string]interface{}, a []interface{} or a string
+func yamlNodeValue(node *yaml.Node, depth int) (interface{}, error) {
+	if depth > maxYAMLDepth {
+		return nil, fmt.Errorf("line %d: the YAML is nested too deeply", node.Line)
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return "", nil
+		}
+		return yamlNodeValue(node.Content[0], depth+1)
+	case yaml.AliasNode:
+		return yamlNodeValue(node.Alias, depth+1)
+	case yaml.SequenceNode:
+		xs := make([]interface{}, 0, len(node.Content))
+		for _, child := range node.Content {
+			value, err := yamlNodeValue(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			xs = append(xs, value)
+		}
+		return xs, nil
+	case yaml.MappingNode:
+		var (
+			m      = make(map[string]interface{}, len(node.Content)/2)
+			merged []map[string]interface{}
+		)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: only scalar mapping keys are supported", key.Line)
+			}
+			v, err := yamlNodeValue(value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if key.Tag == "!!merge" {
+				// "<<: *anchor" merges in the keys of the aliased mapping (or mappings)
+				switch mv := v.(type) {
+				case map[string]interface{}:
+					merged = append(merged, mv)
+				case []interface{}:
+					for _, x := range mv {
+						if mx, ok := x.(map[string]interface{}); ok {
+							merged = append(merged, mx)
+						}
+					}
+				}
+				continue
+			}
+			if _, exists := m[key.Value]; exists {
+				return nil, fmt.Errorf("line %d: duplicate key %q", key.Line, key.Value)
+			}
+			m[key.Value] = v
+		}
+		// The keys that are given in the mapping itself take precedence over the merged keys
+		for _, mm := range merged {
+			for k, v := range mm {
+				if _, exists := m[k]; !exists {
+					m[k] = v
+				}
+			}
+		}
+		return m, nil
+	default:
+		return node.Value, nil
+	}
+}
@@ -0,0 +1,146 @@
+package acode
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []interface{}
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  nil,
+		},
+		{
+			name:  "block mapping with all scalars as strings",
+			input: "name: acode\nport: 8080\nenabled: true\nversion: 1.0\n",
+			want:  []interface{}{map[string]interface{}{"name": "acode", "port": "8080", "enabled": "true", "version": "1.0"}},
+		},
+		{
+			name:  "multi-line plain scalar",
+			input: "metadata:\n  name: acode\n  description: long\n    text\n",
+			want:  []interface{}{map[string]interface{}{"metadata": map[string]interface{}{"name": "acode", "description": "long text"}}},
+		},
+		{
+			name:  "multi-line flow sequence",
+			input: "tags: [go,\n  cli,\n  ai]\n",
+			want:  []interface{}{map[string]interface{}{"tags": []interface{}{"go", "cli", "ai"}}},
+		},
+		{
+			name:  "anchors and aliases",
+			input: "owner: &team platform\nspec:\n  owner: *team\n",
+			want:  []interface{}{map[string]interface{}{"owner": "platform", "spec": map[string]interface{}{"owner": "platform"}}},
+		},
+		{
+			name:  "merge key",
+			input: "base: &base\n  type: service\n  lifecycle: production\nspec:\n  <<: *base\n  lifecycle: experimental\n",
+			want: []interface{}{map[string]interface{}{
+				"base": map[string]interface{}{"type": "service", "lifecycle": "production"},
+				"spec": map[string]interface{}{"type": "service", "lifecycle": "experimental"},
+			}},
+		},
+		{
+			name:  "block sequence and literal block scalar",
+			input: "items:\n  - a\n  - b: c\nscript: |\n  line 1\n  line 2\n",
+			want: []interface{}{map[string]interface{}{
+				"items":  []interface{}{"a", map[string]interface{}{"b": "c"}},
+				"script": "line 1\nline 2\n",
+			}},
+		},
+		{
+			name:  "multiple documents",
+			input: "kind: Component\n---\nkind: API\n",
+			want:  []interface{}{map[string]interface{}{"kind": "Component"}, map[string]interface{}{"kind": "API"}},
+		},
+		{
+			name:  "quoted scalars and comments",
+			input: "a: 'x # y' # comment\nb: \"line\\nbreak\"\n",
+			want:  []interface{}{map[string]interface{}{"a": "x # y", "b": "line\nbreak"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseYAML(tt.input)
+			if err != nil {
+				t.Fatalf("ParseYAML returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseYAML(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unclosed flow sequence", "tags: [a, b\n"},
+		{"duplicate key", "a: 1\na: 2\n"},
+		{"bad indentation", "a:\n  b: 1\n c: 2\n"},
+		{"unknown alias", "a: *missing\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseYAML(tt.input); err == nil {
+				t.Errorf("ParseYAML(%q) returned no error", tt.input)
+			}
+		})
+	}
+}
+
+func TestValidateCatalogMultiLineDescription(t *testing.T) {
+	catalog := `apiVersion: backstage.io/v1alpha1
+kind: Component
+metadata:
+  name: acode
+  description: A tool that generates documentation
+    and finds bugs with AI
+  tags: [go,
+    cli]
+spec:
+  type: service
+  lifecycle: production
+  owner: platform
+`
+	if errs := ValidateCatalog(catalog); len(errs) != 0 {
+		t.Errorf("ValidateCatalog returned errors for a valid catalog: %v", errs)
+	}
+}
+
+func TestValidateCatalogAPIDefinition(t *testing.T) {
+	const api = `apiVersion: backstage.io/v1alpha1
+kind: API
+metadata:
+  name: acode-api
+spec:
+  type: openapi
+  lifecycle: production
+  owner: platform
+  definition: %s
+`
+	tests := []struct {
+		definition string
+		valid      bool
+	}{
+		{"|\n    openapi: 3.1.0", true},
+		{"{$text: ./openapi.yaml}", true},
+		{"\n    $json: https://example.com/openapi.json", true},
+		{"{$text: ./a.yaml, $yaml: ./b.yaml}", false},
+		{"{$file: ./openapi.yaml}", false},
+		{"{$text: [a, b]}", false},
+	}
+	for _, tt := range tests {
+		errs := ValidateCatalog(fmt.Sprintf(api, tt.definition))
+		if valid := len(errs) == 0; valid != tt.valid {
+			t.Errorf("ValidateCatalog() with definition %q gave errors %v, want valid = %v", tt.definition, errs, tt.valid)
+		}
+	}
+}